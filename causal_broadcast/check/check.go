@@ -0,0 +1,393 @@
+// Package check checks the histories of a causal broadcast run.
+//
+// A Recorder keeps the history of the events every node delivered, its own
+// broadcasts included, in the order it delivered them. That's enough to
+// know what a correct run looks like without trusting the clocks: whatever
+// a node delivered before it broadcast a message is in the causal past of
+// that message. The checker works out the causal past of every message
+// from the histories and then checks
+//
+// -> causal order: a node delivers the causal past of a message before it.
+// -> no duplicates: no node delivers a message twice.
+// -> no creation: every message delivered was broadcast by its sender.
+// -> eventual delivery: every message broadcast is delivered everywhere.
+//
+// FromNodes gets the same histories after the fact from nodes that ran
+// without a recorder.
+//
+// Eventual delivery only holds once the run is over and every queue is
+// drained, Safety leaves it out for runs that are still going.
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+// kinds of violations.
+const (
+	CausalOrder = "causal-order"
+	Duplicate   = "duplicate"
+	Creation    = "creation"
+	Undelivered = "undelivered"
+)
+
+// MsgId identifies a broadcast by its sender and the sender's count when
+// it sent it. Clocks only move on sends, so the count is the position of
+// the message among the sender's broadcasts.
+type MsgId struct {
+	Sender string
+	Seq    int
+}
+
+func (m MsgId) String() string { return fmt.Sprintf("%s#%d", m.Sender, m.Seq) }
+
+// idOf returns the id of the message an event delivers. An event without
+// a clock gets 0, nothing is ever sent with it so it shows up as created.
+func idOf(e *node.Event) MsgId {
+	c, err := e.Clock()
+	if err != nil {
+		return MsgId{e.Id, 0}
+	}
+	return MsgId{e.Id, c.Get()}
+}
+
+// History is what a node delivered, in order.
+type History struct {
+	Node   string
+	Events []*node.Event
+}
+
+// Violation is a minimal counterexample to one of the properties.
+type Violation struct {
+	Kind string
+	Node string  // node the violation happened at
+	Msgs []MsgId // the message, for causal order the earlier message first
+
+	// for causal order, how the first message led to the second. every
+	// message in the chain was delivered or sent by the sender of the next
+	// one before it sent it.
+	Chain []MsgId
+}
+
+func (v Violation) String() string {
+	switch v.Kind {
+	case CausalOrder:
+		return fmt.Sprintf("%s: %s delivered %s before %s, causal chain %v", v.Kind, v.Node, v.Msgs[1], v.Msgs[0], v.Chain)
+	case Undelivered:
+		return fmt.Sprintf("%s: %s never delivered %s", v.Kind, v.Node, v.Msgs[0])
+	}
+	return fmt.Sprintf("%s: %s delivered %s", v.Kind, v.Node, v.Msgs[0])
+}
+
+// Recorder records the histories of nodes as they run. The History of a
+// node only has what it delivered from the others, the recorder hooks
+// OnDeliver to get the node's own broadcasts too, where it made them.
+type Recorder struct {
+	hs []History
+}
+
+// Record starts recording the nodes. The OnDeliver the nodes already had
+// is still called, anything that sets it later has to be set up first.
+func Record(nodes ...*node.Node) *Recorder {
+	r := &Recorder{hs: make([]History, len(nodes))}
+	for i, n := range nodes {
+		i, next := i, n.OnDeliver
+		r.hs[i].Node = n.Id
+		n.OnDeliver = func(e *node.Event) {
+			r.hs[i].Events = append(r.hs[i].Events, e)
+			if next != nil {
+				next(e)
+			}
+		}
+	}
+	return r
+}
+
+// Histories returns what the nodes delivered so far.
+func (r *Recorder) Histories() []History {
+	hs := make([]History, len(r.hs))
+	for i, h := range r.hs {
+		hs[i] = History{Node: h.Node, Events: append([]*node.Event(nil), h.Events...)}
+	}
+	return hs
+}
+
+// FromNodes takes the histories out of nodes that ran without a Recorder.
+// History only has what a node delivered from the others, its own
+// broadcasts are put back in from the copies the others delivered. A
+// copy's timestamp says how much of each sender the node had delivered
+// when it sent it, so it goes right after those. The node's own entry
+// only moves on its sends, it says how many there were, the ones nobody
+// delivered go in just before the next send somebody did, with no msg.
+func FromNodes(nodes ...*node.Node) ([]History, error) {
+	copies := make(map[MsgId]*node.Event)
+	delivered := make([][]*node.Event, len(nodes))
+	for i, n := range nodes {
+		for _, raw := range n.History {
+			e, err := node.Unmarshal([]byte(raw))
+			if err != nil {
+				return nil, fmt.Errorf("history of %s: %v", n.Id, err)
+			}
+			delivered[i] = append(delivered[i], e)
+			if _, ok := copies[idOf(e)]; !ok {
+				copies[idOf(e)] = e
+			}
+		}
+	}
+
+	hs := make([]History, len(nodes))
+	for i, n := range nodes {
+		hs[i].Node = n.Id
+		evs := delivered[i]
+		var missing []int // sends nobody delivered yet
+		for k := 1; k <= n.Clock.Get(); k++ {
+			sent, ok := copies[MsgId{n.Id, k}]
+			if !ok {
+				missing = append(missing, k)
+				continue
+			}
+			ts, _ := sent.Clock() // it has one, idOf found k in it
+			for len(evs) > 0 && idOf(evs[0]).Seq <= ts.GetMember(evs[0].Id) {
+				hs[i].Events = append(hs[i].Events, evs[0])
+				evs = evs[1:]
+			}
+			hs[i].Events = append(hs[i].Events, unknownSends(n.Id, missing, sent.Timestamp)...)
+			hs[i].Events = append(hs[i].Events, sent)
+			missing = nil
+		}
+		hs[i].Events = append(hs[i].Events, evs...)
+		hs[i].Events = append(hs[i].Events, unknownSends(n.Id, missing, n.Clock)...)
+	}
+	return hs, nil
+}
+
+// unknownSends makes up the sends of a node nobody delivered, stamped
+// with what the node had seen by then.
+func unknownSends(id string, seqs []int, seen *clock.Vector) []*node.Event {
+	evs := make([]*node.Event, len(seqs))
+	for i, k := range seqs {
+		ts := seen.Copy()
+		ts.AddMember(id, k)
+		evs[i] = &node.Event{Id: id, Timestamp: ts}
+	}
+	return evs
+}
+
+// Write writes histories as a json object of node ids to their events.
+func Write(w io.Writer, hs []History) error {
+	m := make(map[string][]*node.Event, len(hs))
+	for _, h := range hs {
+		m[h.Node] = h.Events
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// Read reads histories written by Write, sorted by node id.
+func Read(r io.Reader) ([]History, error) {
+	var m map[string][]*node.Event
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	hs := make([]History, 0, len(m))
+	for id, evs := range m {
+		hs = append(hs, History{Node: id, Events: evs})
+	}
+	sort.Slice(hs, func(i, j int) bool { return hs[i].Node < hs[j].Node })
+	return hs, nil
+}
+
+// Save writes histories to a file.
+func Save(path string, hs []History) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, hs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads histories from a file.
+func Load(path string) ([]History, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// run is what the checker knows about a run.
+type run struct {
+	sent   map[MsgId]bool
+	before map[MsgId][]MsgId // messages the sender saw before sending
+	past   map[MsgId]map[MsgId]bool
+}
+
+func newRun(hs []History) *run {
+	r := &run{
+		sent:   make(map[MsgId]bool),
+		before: make(map[MsgId][]MsgId),
+		past:   make(map[MsgId]map[MsgId]bool),
+	}
+	for _, h := range hs {
+		var seen []MsgId
+		for _, e := range h.Events {
+			m := idOf(e)
+			if e.Id == h.Node && !r.sent[m] {
+				r.sent[m] = true
+				r.before[m] = append([]MsgId(nil), seen...)
+			}
+			seen = append(seen, m)
+		}
+	}
+	return r
+}
+
+// pastOf returns the causal past of a message, worked out once.
+func (r *run) pastOf(m MsgId) map[MsgId]bool {
+	if p, ok := r.past[m]; ok {
+		return p
+	}
+	p := make(map[MsgId]bool)
+	r.past[m] = p // a message can't be in its own past, this stops loops
+	for _, b := range r.before[m] {
+		if b == m {
+			continue
+		}
+		p[b] = true
+		for pb := range r.pastOf(b) {
+			p[pb] = true
+		}
+	}
+	return p
+}
+
+// chain finds the shortest way from one message to another through the
+// messages senders saw before sending.
+func (r *run) chain(from, to MsgId) []MsgId {
+	prev := map[MsgId]MsgId{to: to}
+	queue := []MsgId{to}
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+		if m == from {
+			break
+		}
+		for _, b := range r.before[m] {
+			if _, ok := prev[b]; !ok {
+				prev[b] = m
+				queue = append(queue, b)
+			}
+		}
+	}
+	path := []MsgId{from}
+	for m := from; m != to; {
+		m = prev[m]
+		path = append(path, m)
+	}
+	return path
+}
+
+// Check checks the histories of a run and returns the violations found,
+// sorted by kind, node and message. A message delivered out of order is
+// reported once, with the closest message it overtook.
+func Check(hs []History) []Violation {
+	r := newRun(hs)
+	var vs []Violation
+
+	for _, h := range hs {
+		delivered := make(map[MsgId]bool)
+		for _, e := range h.Events {
+			m := idOf(e)
+			if delivered[m] {
+				vs = append(vs, Violation{Kind: Duplicate, Node: h.Node, Msgs: []MsgId{m}})
+				continue
+			}
+			if !r.sent[m] {
+				vs = append(vs, Violation{Kind: Creation, Node: h.Node, Msgs: []MsgId{m}})
+			}
+
+			// messages in the past of m the node hasn't delivered yet.
+			// the ones in the past of another missing message are
+			// covered by that one, so only the closest are reported.
+			past := r.pastOf(m)
+			var missing []MsgId
+			for p := range past {
+				if !delivered[p] {
+					missing = append(missing, p)
+				}
+			}
+			for _, p := range missing {
+				covered := false
+				for _, q := range missing {
+					if q != p && r.pastOf(q)[p] {
+						covered = true
+						break
+					}
+				}
+				if !covered {
+					vs = append(vs, Violation{
+						Kind:  CausalOrder,
+						Node:  h.Node,
+						Msgs:  []MsgId{p, m},
+						Chain: r.chain(p, m),
+					})
+				}
+			}
+			delivered[m] = true
+		}
+
+		for m := range r.sent {
+			if !delivered[m] {
+				vs = append(vs, Violation{Kind: Undelivered, Node: h.Node, Msgs: []MsgId{m}})
+			}
+		}
+	}
+
+	sort.Slice(vs, func(i, j int) bool {
+		a, b := vs[i], vs[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		for k := 0; k < len(a.Msgs) && k < len(b.Msgs); k++ {
+			if a.Msgs[k] != b.Msgs[k] {
+				return lessMsg(a.Msgs[k], b.Msgs[k])
+			}
+		}
+		return len(a.Msgs) < len(b.Msgs)
+	})
+	return vs
+}
+
+// Safety leaves out the undelivered messages, for runs that aren't over.
+func Safety(vs []Violation) []Violation {
+	var safe []Violation
+	for _, v := range vs {
+		if v.Kind != Undelivered {
+			safe = append(safe, v)
+		}
+	}
+	return safe
+}
+
+func lessMsg(a, b MsgId) bool {
+	if a.Sender != b.Sender {
+		return a.Sender < b.Sender
+	}
+	return a.Seq < b.Seq
+}
@@ -0,0 +1,165 @@
+package check
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+// ev makes the event of the seq'th broadcast of a sender, the clocks of
+// the other senders don't matter to the checker.
+func ev(sender string, seq int) *node.Event {
+	c := clock.New(sender)
+	c.AddMember(sender, seq)
+	return &node.Event{Id: sender, Timestamp: c, Msg: fmt.Sprintf("%s%d", sender, seq)}
+}
+
+func kinds(vs []Violation) map[string]int {
+	k := make(map[string]int)
+	for _, v := range vs {
+		k[v.Kind]++
+	}
+	return k
+}
+
+func TestCheckGoodRun(t *testing.T) {
+	nodes := []*node.Node{node.New("a"), node.New("b"), node.New("c")}
+	for _, n := range nodes {
+		for _, other := range nodes {
+			if other != n {
+				n.Clock.AddMember(other.Id, 0)
+			}
+		}
+	}
+	rec := Record(nodes...)
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	p, _ := a.GenEvent("question")
+	b.Write(p)
+	q, _ := b.GenEvent("answer")
+	c.Write(p)
+	c.Write(q)
+	a.Write(q)
+
+	hs := rec.Histories()
+	if len(hs[1].Events) != 2 || hs[1].Events[1].Id != "b" {
+		t.Fatalf("expected b's answer after what it delivered, got %v", hs[1].Events)
+	}
+	if vs := Check(hs); len(vs) != 0 {
+		t.Errorf("expected a clean run, got %v", vs)
+	}
+}
+
+func TestFromNodes(t *testing.T) {
+	nodes := []*node.Node{node.New("a"), node.New("b"), node.New("c")}
+	for _, n := range nodes {
+		for _, other := range nodes {
+			if other != n {
+				n.Clock.AddMember(other.Id, 0)
+			}
+		}
+	}
+	rec := Record(nodes...)
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	p, _ := a.GenEvent("question")
+	b.Write(p)
+	q, _ := b.GenEvent("answer")
+	c.Write(p)
+	c.Write(q)
+	a.Write(q)
+	c.GenEvent("aside") // nobody delivers this one
+
+	hs, err := FromNodes(nodes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rec.Histories()
+	for i, h := range hs {
+		if len(h.Events) != len(want[i].Events) {
+			t.Fatalf("%s: expected %d events, got %d", h.Node, len(want[i].Events), len(h.Events))
+		}
+		for j, e := range h.Events {
+			if idOf(e) != idOf(want[i].Events[j]) {
+				t.Errorf("%s: expected %s at %d, got %s", h.Node, idOf(want[i].Events[j]), j, idOf(e))
+			}
+		}
+	}
+	if got := kinds(Check(hs)); len(got) != 1 || got[Undelivered] != 2 {
+		t.Errorf("expected the aside undelivered at a and b, got %v", got)
+	}
+}
+
+func TestCheckCausalOrder(t *testing.T) {
+	// a1 -> b1 -> c1, d delivers c1 before either of the others. only the
+	// closest message c1 overtook is reported.
+	hs := []History{
+		{Node: "a", Events: []*node.Event{ev("a", 1), ev("b", 1), ev("c", 1)}},
+		{Node: "b", Events: []*node.Event{ev("a", 1), ev("b", 1), ev("c", 1)}},
+		{Node: "c", Events: []*node.Event{ev("a", 1), ev("b", 1), ev("c", 1)}},
+		{Node: "d", Events: []*node.Event{ev("c", 1), ev("b", 1), ev("a", 1)}},
+	}
+	vs := Check(hs)
+	if len(vs) != 2 {
+		t.Fatalf("expected 2 violations, got %v", vs)
+	}
+	// d delivered c1 before b1, then b1 before a1.
+	if vs[0].Node != "d" || vs[0].Msgs[0] != (MsgId{"a", 1}) || vs[0].Msgs[1] != (MsgId{"b", 1}) {
+		t.Errorf("unexpected counterexample %v", vs[0])
+	}
+	if vs[1].Msgs[0] != (MsgId{"b", 1}) || vs[1].Msgs[1] != (MsgId{"c", 1}) {
+		t.Errorf("unexpected counterexample %v", vs[1])
+	}
+	if fmt.Sprint(vs[1].Chain) != "[b#1 c#1]" {
+		t.Errorf("expected the direct chain, got %v", vs[1].Chain)
+	}
+	t.Log(vs)
+}
+
+func TestCheckDuplicatesCreationAndLoss(t *testing.T) {
+	hs := []History{
+		{Node: "a", Events: []*node.Event{ev("a", 1), ev("a", 2)}},
+		{Node: "b", Events: []*node.Event{ev("a", 1), ev("a", 1), ev("z", 1)}},
+	}
+	k := kinds(Check(hs))
+	if k[Duplicate] != 1 || k[Creation] != 1 || k[Undelivered] != 1 {
+		t.Errorf("expected a duplicate, a creation and a lost message, got %v", k)
+	}
+	if k := kinds(Safety(Check(hs))); k[Undelivered] != 0 {
+		t.Error("Safety should leave out undelivered messages")
+	}
+
+	// an event without a clock is nothing a ever sent.
+	hs[1].Events = append(hs[1].Events, &node.Event{Id: "a", Msg: "no clock"})
+	if k := kinds(Check(hs)); k[Creation] != 2 {
+		t.Errorf("expected the event without a clock to be created, got %v", k)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	hs := []History{
+		{Node: "b", Events: []*node.Event{ev("a", 1), ev("b", 1)}},
+		{Node: "a", Events: []*node.Event{ev("a", 1), ev("b", 1)}},
+	}
+	dir, err := os.MkdirTemp("", "check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.json")
+	if err := Save(path, hs); err != nil {
+		t.Fatal(err)
+	}
+	back, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back) != 2 || back[0].Node != "a" || len(back[1].Events) != 2 {
+		t.Fatalf("unexpected histories %v", back)
+	}
+	if vs := Check(back); len(vs) != 0 {
+		t.Errorf("expected a clean run, got %v", vs)
+	}
+}
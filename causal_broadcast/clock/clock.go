@@ -2,7 +2,9 @@
 package clock
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -28,9 +30,54 @@ func (v *Vector) GetId() string {
 	return v.id
 }
 
-// String returns a nicely formatted string of clocks value
+// String returns a nicely formatted string of clocks value, [a:1 b:2]
+// with the ids sorted.
 func (v *Vector) String() string {
-	return strings.Split(fmt.Sprintf("%v", v.val), "p")[1]
+	ids := make([]string, 0, len(v.val))
+	for id := range v.val {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%s:%d", id, v.val[id])
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// MarshalJSON writes the clock as a json object of ids to counts.
+func (v *Vector) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.val)
+}
+
+// UnmarshalJSON reads the clock back from a json object. The id of the
+// clock isn't part of it, a clock made with New keeps its id.
+func (v *Vector) UnmarshalJSON(p []byte) error {
+	val := make(map[string]int)
+	if err := json.Unmarshal(p, &val); err != nil {
+		return err
+	}
+	v.val = val
+	return nil
+}
+
+// Values returns a copy of the counts in the clock.
+func (v *Vector) Values() map[string]int {
+	val := make(map[string]int, len(v.val))
+	for id, n := range v.val {
+		val[id] = n
+	}
+	return val
+}
+
+// Copy returns a clock with the same id and counts.
+func (v *Vector) Copy() *Vector {
+	return &Vector{id: v.id, val: v.Values()}
+}
+
+// GetMember returns the count of a member, 0 if the clock doesn't have it.
+func (v *Vector) GetMember(id string) int {
+	return v.val[id]
 }
 
 // Get the latest timestamp of the Vector
@@ -89,3 +136,60 @@ func (v *Vector) HappensBefore(vec *Vector) bool {
 	}
 	return true
 }
+
+// Ordering is how two clocks are related.
+type Ordering int
+
+const (
+	Equal Ordering = iota
+	Before
+	After
+	Concurrent
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case Equal:
+		return "equal"
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	}
+	return "concurrent"
+}
+
+// Compare compares every count in the two clocks, counts a clock doesn't
+// have are 0. Before means v happened before vec.
+func (v *Vector) Compare(vec *Vector) Ordering {
+	less, more := false, false
+	for id, val := range v.val {
+		if val < vec.val[id] {
+			less = true
+		} else if val > vec.val[id] {
+			more = true
+		}
+	}
+	for id, val := range vec.val {
+		if _, ok := v.val[id]; !ok && val > 0 {
+			less = true
+		}
+	}
+	switch {
+	case less && more:
+		return Concurrent
+	case less:
+		return Before
+	case more:
+		return After
+	}
+	return Equal
+}
+
+// Dot is a single event of a node, its seq'th.
+type Dot struct {
+	Id  string `json:"id"`
+	Seq int    `json:"seq"`
+}
+
+func (d Dot) String() string { return fmt.Sprintf("%s:%d", d.Id, d.Seq) }
@@ -1,6 +1,7 @@
 package clock
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 )
@@ -64,3 +65,52 @@ func TestAddMember(t *testing.T) {
 		fmt.Println(cl)
 	}
 }
+
+func TestVectorSerialization(t *testing.T) {
+	// ids with a p in them used to break String.
+	v := New("peer")
+	v.AddMember("pop", 3)
+	v.Increment()
+	if v.String() != "[peer:1 pop:3]" {
+		t.Errorf("expected [peer:1 pop:3], got %s", v.String())
+	}
+
+	p, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != `{"peer":1,"pop":3}` {
+		t.Errorf("unexpected json %s", p)
+	}
+	back := New("peer")
+	if err := json.Unmarshal(p, back); err != nil {
+		t.Fatal(err)
+	}
+	if back.String() != v.String() || back.Get() != 1 {
+		t.Errorf("expected %s back, got %s", v, back)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	a, b := New("a"), New("b")
+	if o := a.Compare(b); o != Equal {
+		t.Errorf("expected fresh clocks to be equal, got %s", o)
+	}
+	a.Increment()
+	if o := b.Compare(a); o != Before {
+		t.Errorf("expected before, got %s", o)
+	}
+	b.Increment()
+	if o := a.Compare(b); o != Concurrent {
+		t.Errorf("expected concurrent, got %s", o)
+	}
+	b.Merge(a)
+	if o := b.Compare(a); o != After {
+		t.Errorf("expected after, got %s", o)
+	}
+	c := b.Copy()
+	c.Increment()
+	if b.Compare(c) != Before || c.GetId() != "b" {
+		t.Errorf("expected the copy to move on its own, %s and %s", b, c)
+	}
+}
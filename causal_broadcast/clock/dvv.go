@@ -0,0 +1,279 @@
+package clock
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+* In a client-server store the servers keep the clocks, clients only carry
+* around the context of what they read. A version vector with an entry per
+* server can't tell two clients writing through the same server apart:
+* the server bumps its entry for each write, so the second write looks
+* like it saw the first one and overwrites it, a lost update.
+*
+* A dotted version vector splits the clock of a write in two, the dot is
+* the write itself and the vector is what the client had read when it
+* made it. Two writes are ordered only when the dot of one is in the past
+* of the other, so writes from clients that didn't see each other stay
+* siblings, whatever server took them.
+* */
+
+// DVV is a dotted version vector, the clock of a single write.
+type DVV struct {
+	Dot  Dot
+	Past map[string]int // what the writer had seen
+}
+
+func (d DVV) String() string {
+	return fmt.Sprintf("(%s, %s)", d.Dot, (&Vector{val: d.Past}).String())
+}
+
+// Contains reports whether the event is the write or in its past.
+func (d DVV) Contains(x Dot) bool {
+	return x == d.Dot || x.Seq <= d.Past[x.Id]
+}
+
+// Compare orders two writes by whether one is in the past of the other.
+func (d DVV) Compare(o DVV) Ordering {
+	switch {
+	case d.Dot == o.Dot:
+		return Equal
+	case o.Contains(d.Dot):
+		return Before
+	case d.Contains(o.Dot):
+		return After
+	}
+	return Concurrent
+}
+
+// Version is a value and the clock of the write that put it there.
+type Version struct {
+	Value string
+	Clock DVV
+}
+
+// Sync merges the versions of a key two servers have, dropping the ones
+// that another version in either of them overwrote. What's left are the
+// siblings, every one concurrent with the others.
+func Sync(a, b []Version) []Version {
+	all := append(append([]Version(nil), a...), b...)
+	var synced []Version
+	for i, v := range all {
+		keep := true
+		for j, o := range all {
+			switch v.Clock.Compare(o.Clock) {
+			case Before:
+				keep = false
+			case Equal:
+				keep = keep && i <= j // the first copy of a duplicate
+			}
+		}
+		if keep {
+			synced = append(synced, v)
+		}
+	}
+	sortVersions(synced)
+	return synced
+}
+
+// Join returns the context of the versions, everything they've seen. It's
+// what a client gets back with the values when it reads a key.
+func Join(vs []Version) *Vector {
+	ctx := &Vector{val: make(map[string]int)}
+	for _, v := range vs {
+		for id, n := range v.Clock.Past {
+			if n > ctx.val[id] {
+				ctx.val[id] = n
+			}
+		}
+		if d := v.Clock.Dot; d.Seq > ctx.val[d.Id] {
+			ctx.val[d.Id] = d.Seq
+		}
+	}
+	return ctx
+}
+
+// Update is a server writing a value a client sent with the context it
+// read. The versions the client had seen are overwritten, the write gets
+// the next dot of the server and the context as its past, and the ones
+// left are its siblings. ctx is nil for a client that didn't read first.
+func Update(vs []Version, ctx *Vector, server, value string) []Version {
+	past := make(map[string]int)
+	if ctx != nil {
+		past = ctx.Values()
+	}
+	seq := Join(vs).val[server]
+	if past[server] > seq {
+		seq = past[server]
+	}
+	w := Version{Value: value, Clock: DVV{Dot: Dot{Id: server, Seq: seq + 1}, Past: past}}
+
+	updated := []Version{w}
+	for _, v := range vs {
+		if !w.Clock.Contains(v.Clock.Dot) {
+			updated = append(updated, v)
+		}
+	}
+	sortVersions(updated)
+	return updated
+}
+
+func sortVersions(vs []Version) {
+	sort.Slice(vs, func(i, j int) bool {
+		a, b := vs[i].Clock.Dot, vs[j].Clock.Dot
+		if a.Id != b.Id {
+			return a.Id < b.Id
+		}
+		return a.Seq < b.Seq
+	})
+}
+
+/*
+* A DVV per sibling repeats the past over and over. A DVV set keeps one
+* entry per server instead: its count and the values of its latest writes
+* that are still siblings, newest first. The value at position i of a
+* server's list was written with the dot (server, count-i). Whatever a
+* sibling's past was is folded into the counts, which is all Update and
+* Sync need to know.
+* */
+
+// DVVSet is the versions of a key as a dotted version vector set.
+type DVVSet struct {
+	entries map[string]*dvvEntry
+}
+
+type dvvEntry struct {
+	n    int
+	vals []string // vals[i] was written with the dot n-i
+}
+
+// NewDVVSet returns an empty set.
+func NewDVVSet() *DVVSet {
+	return &DVVSet{entries: make(map[string]*dvvEntry)}
+}
+
+func (s *DVVSet) entry(id string) *dvvEntry {
+	e, ok := s.entries[id]
+	if !ok {
+		e = &dvvEntry{}
+		s.entries[id] = e
+	}
+	return e
+}
+
+// has reports whether the set has a value for the dot, and seen whether
+// it knows about the dot at all.
+func (s *DVVSet) has(d Dot) (val string, has, seen bool) {
+	e, ok := s.entries[d.Id]
+	if !ok || d.Seq > e.n {
+		return "", false, false
+	}
+	if i := e.n - d.Seq; i < len(e.vals) {
+		return e.vals[i], true, true
+	}
+	return "", false, true
+}
+
+// Update is a server writing a value a client sent with the context it
+// read, the same as Update for DVVs. ctx is nil for a client that didn't
+// read first.
+func (s *DVVSet) Update(ctx *Vector, server, value string) {
+	if ctx != nil {
+		for id, n := range ctx.val {
+			e := s.entry(id)
+			// the values the client had seen are gone.
+			keep := e.n - n
+			if keep < 0 {
+				keep = 0
+			}
+			if keep < len(e.vals) {
+				e.vals = e.vals[:keep]
+			}
+			if n > e.n {
+				e.n = n
+			}
+		}
+	}
+	e := s.entry(server)
+	e.n++
+	e.vals = append([]string{value}, e.vals...)
+}
+
+// Sync merges in the set another server has. A value stays if both have
+// it, or if the one without it never saw its dot.
+func (s *DVVSet) Sync(o *DVVSet) {
+	ids := make(map[string]bool)
+	for id := range s.entries {
+		ids[id] = true
+	}
+	for id := range o.entries {
+		ids[id] = true
+	}
+	for id := range ids {
+		n := 0
+		for _, e := range []*dvvEntry{s.entries[id], o.entries[id]} {
+			if e != nil && e.n > n {
+				n = e.n
+			}
+		}
+		var vals []string
+		for seq := n; seq > 0; seq-- {
+			d := Dot{Id: id, Seq: seq}
+			va, hasA, seenA := s.has(d)
+			vb, hasB, seenB := o.has(d)
+			if hasA && (hasB || !seenB) {
+				vals = append(vals, va)
+			} else if hasB && !seenA {
+				vals = append(vals, vb)
+			} else {
+				// what's left can only be older values one of them
+				// overwrote.
+				break
+			}
+		}
+		s.entries[id] = &dvvEntry{n: n, vals: vals}
+	}
+}
+
+// Join returns the context of the set, the count of every server.
+func (s *DVVSet) Join() *Vector {
+	ctx := &Vector{val: make(map[string]int)}
+	for id, e := range s.entries {
+		if e.n > 0 {
+			ctx.val[id] = e.n
+		}
+	}
+	return ctx
+}
+
+// Values returns the values of the siblings, by server and oldest first.
+func (s *DVVSet) Values() []string {
+	ids := make([]string, 0, len(s.entries))
+	for id := range s.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var vals []string
+	for _, id := range ids {
+		e := s.entries[id]
+		for i := len(e.vals) - 1; i >= 0; i-- {
+			vals = append(vals, e.vals[i])
+		}
+	}
+	return vals
+}
+
+func (s *DVVSet) String() string {
+	ids := make([]string, 0, len(s.entries))
+	for id := range s.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%s:%d%q", id, s.entries[id].n, s.entries[id].vals)
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
@@ -0,0 +1,132 @@
+package clock
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func values(vs []Version) []string {
+	var vals []string
+	for _, v := range vs {
+		vals = append(vals, v.Value)
+	}
+	sort.Strings(vals)
+	return vals
+}
+
+func TestSameServerSiblings(t *testing.T) {
+	// two clients read the empty key and write through the same server.
+	var vs []Version
+	ctx := Join(vs)
+	vs = Update(vs, ctx, "s", "from a")
+	vs = Update(vs, ctx, "s", "from b")
+	if got := values(vs); !reflect.DeepEqual(got, []string{"from a", "from b"}) {
+		t.Fatalf("expected both writes as siblings, got %v", vs)
+	}
+	if o := vs[0].Clock.Compare(vs[1].Clock); o != Concurrent {
+		t.Errorf("expected the writes to be concurrent, got %s", o)
+	}
+
+	// a version vector per server orders them and loses one.
+	va, vb := New("s"), New("s")
+	va.Increment()
+	vb.Merge(va)
+	vb.Increment()
+	if va.Compare(vb) != Before {
+		t.Errorf("expected the server's vector to order the writes, got %s", va.Compare(vb))
+	}
+
+	// a client that read both overwrites both.
+	vs = Update(vs, Join(vs), "s", "merged")
+	if got := values(vs); !reflect.DeepEqual(got, []string{"merged"}) {
+		t.Errorf("expected the merge to overwrite the siblings, got %v", vs)
+	}
+
+	set := NewDVVSet()
+	empty := set.Join()
+	set.Update(empty, "s", "from a")
+	set.Update(empty, "s", "from b")
+	if got := set.Values(); !reflect.DeepEqual(got, []string{"from a", "from b"}) {
+		t.Errorf("expected both writes in the set, got %s", set)
+	}
+	set.Update(set.Join(), "s", "merged")
+	if got := set.Values(); !reflect.DeepEqual(got, []string{"merged"}) {
+		t.Errorf("expected the merge to overwrite the set, got %s", set)
+	}
+}
+
+func TestSync(t *testing.T) {
+	// a write on s1 that s2 overwrites after syncing, and one that s2
+	// never saw.
+	s1 := Update(nil, nil, "s1", "old")
+	s2 := Sync(nil, s1)
+	s2 = Update(s2, Join(s2), "s2", "new")
+	s1 = Update(s1, nil, "s1", "other")
+
+	synced := Sync(s1, s2)
+	if got := values(synced); !reflect.DeepEqual(got, []string{"new", "other"}) {
+		t.Errorf("expected new and other, got %v", synced)
+	}
+	if !reflect.DeepEqual(Sync(s2, s1), synced) || !reflect.DeepEqual(Sync(synced, synced), synced) {
+		t.Errorf("expected sync to be commutative and idempotent")
+	}
+}
+
+// TestDVVSetMatchesDVV runs the same random writes and syncs on servers
+// keeping DVVs and servers keeping DVV sets, with clients writing with
+// contexts they read at random earlier times. Both have to end up with
+// the same siblings.
+func TestDVVSetMatchesDVV(t *testing.T) {
+	servers := []string{"s0", "s1", "s2"}
+	for seed := int64(0); seed < 200; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		dvvs := make(map[string][]Version)
+		sets := make(map[string]*DVVSet)
+		for _, s := range servers {
+			sets[s] = NewDVVSet()
+		}
+		var ctxs []*Vector
+
+		for step := 0; step < 40; step++ {
+			s := servers[rnd.Intn(len(servers))]
+			switch rnd.Intn(3) {
+			case 0:
+				ctx := Join(dvvs[s])
+				if !reflect.DeepEqual(ctx.val, sets[s].Join().val) {
+					t.Fatalf("seed %d: contexts %s and %s", seed, ctx, sets[s].Join())
+				}
+				ctxs = append(ctxs, ctx)
+			case 1:
+				var ctx *Vector
+				if len(ctxs) > 0 && rnd.Intn(4) > 0 {
+					ctx = ctxs[rnd.Intn(len(ctxs))]
+				}
+				value := strconv.Itoa(step)
+				dvvs[s] = Update(dvvs[s], ctx, s, value)
+				sets[s].Update(ctx, s, value)
+			case 2:
+				from := servers[rnd.Intn(len(servers))]
+				dvvs[s] = Sync(dvvs[s], dvvs[from])
+				sets[s].Sync(sets[from])
+			}
+
+			for _, s := range servers {
+				got, want := sets[s].Values(), values(dvvs[s])
+				sort.Strings(got)
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("seed %d, step %d: %s has %v with DVVs and %v with a DVV set", seed, step, s, want, got)
+				}
+				for i, v := range dvvs[s] {
+					for _, o := range dvvs[s][i+1:] {
+						if v.Clock.Compare(o.Clock) != Concurrent {
+							t.Fatalf("seed %d: siblings %s and %s aren't concurrent", seed, v.Clock, o.Clock)
+						}
+					}
+				}
+			}
+		}
+	}
+}
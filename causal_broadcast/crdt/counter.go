@@ -0,0 +1,56 @@
+package crdt
+
+import (
+	"encoding/json"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+// PNCounter is a counter that goes up and down. Adding commutes, so the
+// operation is just the amount, the increments and decrements are kept
+// apart only to be looked at.
+type PNCounter struct {
+	r    *Replica
+	name string
+	c    *pnCounter
+}
+
+type pnCounter struct {
+	p, n int
+}
+
+type counterOp struct {
+	N int `json:"n"`
+}
+
+func newPNCounter() *pnCounter { return &pnCounter{} }
+
+func (c *pnCounter) effect(raw json.RawMessage, e *node.Event) error {
+	var op counterOp
+	if err := json.Unmarshal(raw, &op); err != nil {
+		return err
+	}
+	if op.N > 0 {
+		c.p += op.N
+	} else {
+		c.n -= op.N
+	}
+	return nil
+}
+
+// Inc adds n to the counter, n can be negative.
+func (c *PNCounter) Inc(n int) ([]byte, error) {
+	return c.r.send(c.name, counterType, counterOp{n})
+}
+
+// Dec takes n off the counter.
+func (c *PNCounter) Dec(n int) ([]byte, error) {
+	return c.Inc(-n)
+}
+
+// Value returns the count.
+func (c *PNCounter) Value() int {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	return c.c.p - c.c.n
+}
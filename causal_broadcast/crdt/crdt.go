@@ -0,0 +1,187 @@
+// Package crdt has operation based CRDTs that replicate over causal
+// broadcast.
+//
+// An operation based CRDT turns every update into an operation that is
+// broadcast to every replica, and every replica applies every operation
+// once. Operations that are concurrent have to commute, the ones that
+// aren't are applied in causal order, and causal broadcast hands out
+// exactly that. So the replicas converge once they've delivered the same
+// operations, whatever order the network brought them in.
+//
+// A Replica is a node and the objects on it. Updates are prepared against
+// the state of the replica and broadcast, the operation is applied when
+// the node delivers it, on the replica that made it too.
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+// dotOf returns the dot of the operation an event carries, the node that
+// made it and the node's count when it did. Objects use it to tell
+// elements apart.
+func dotOf(e *node.Event) (clock.Dot, error) {
+	c, err := e.Clock()
+	if err != nil {
+		return clock.Dot{}, err
+	}
+	return clock.Dot{Id: e.Id, Seq: c.Get()}, nil
+}
+
+// Op is the message of an event carrying an operation. The type of the
+// object goes along, so a replica that hasn't heard of the object yet
+// makes the right one.
+type Op struct {
+	Object string          `json:"object"`
+	Type   string          `json:"type"`
+	Op     json.RawMessage `json:"op"`
+}
+
+// object is a CRDT living on a replica.
+type object interface {
+	// effect applies an operation delivered in the event.
+	effect(op json.RawMessage, e *node.Event) error
+}
+
+// types of objects.
+const (
+	counterType  = "pn-counter"
+	setType      = "or-set"
+	registerType = "mv-register"
+	textType     = "rga"
+)
+
+var constructors = map[string]func() object{
+	counterType:  func() object { return newPNCounter() },
+	setType:      func() object { return newORSet() },
+	registerType: func() object { return newMVRegister() },
+	textType:     func() object { return newRGA() },
+}
+
+// Replica is a node and the objects replicated on it.
+type Replica struct {
+	node *node.Node
+
+	mu      sync.Mutex
+	objects map[string]object
+	types   map[string]string
+	err     error // first operation that couldn't be applied
+}
+
+// NewReplica makes a replica of the node. The replica takes over the
+// node's OnDeliver.
+func NewReplica(n *node.Node) *Replica {
+	r := &Replica{
+		node:    n,
+		objects: make(map[string]object),
+		types:   make(map[string]string),
+	}
+	n.OnDeliver = r.apply
+	return r
+}
+
+// Write hands an operation broadcast by another replica to this one.
+func (r *Replica) Write(p []byte) (int, error) {
+	return r.node.Write(p)
+}
+
+// Err returns the first delivered operation the replica couldn't apply.
+func (r *Replica) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// object returns the object with the name, making it if it's new. The
+// caller holds the lock.
+func (r *Replica) object(name, typ string) (object, error) {
+	if o, ok := r.objects[name]; ok {
+		if r.types[name] != typ {
+			return nil, fmt.Errorf("crdt: %s is a %s, not a %s", name, r.types[name], typ)
+		}
+		return o, nil
+	}
+	newObject, ok := constructors[typ]
+	if !ok {
+		return nil, fmt.Errorf("crdt: unknown type %s", typ)
+	}
+	o := newObject()
+	r.objects[name], r.types[name] = o, typ
+	return o, nil
+}
+
+// send broadcasts an operation on an object. The replica applies it
+// before send returns.
+func (r *Replica) send(name, typ string, op interface{}) ([]byte, error) {
+	raw, err := json.Marshal(op)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := json.Marshal(Op{Object: name, Type: typ, Op: raw})
+	if err != nil {
+		return nil, err
+	}
+	return r.node.GenEvent(string(msg))
+}
+
+// apply applies a delivered operation.
+func (r *Replica) apply(e *node.Event) {
+	var op Op
+	if err := json.Unmarshal([]byte(e.Msg), &op); err != nil {
+		return // not an operation
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, err := dotOf(e)
+	if err != nil {
+		if r.err == nil {
+			r.err = fmt.Errorf("crdt: applying %s from %s: %v", op.Object, e.Id, err)
+		}
+		return
+	}
+	o, err := r.object(op.Object, op.Type)
+	if err == nil {
+		err = o.effect(op.Op, e)
+	}
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("crdt: applying %s from %s: %v", op.Object, d, err)
+	}
+}
+
+// lookup is object for the accessors, which can't fail. A name taken by
+// another type panics, that's a bug in the caller.
+func (r *Replica) lookup(name, typ string) object {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, err := r.object(name, typ)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+// Counter returns the counter with the name.
+func (r *Replica) Counter(name string) *PNCounter {
+	return &PNCounter{r, name, r.lookup(name, counterType).(*pnCounter)}
+}
+
+// Set returns the set with the name.
+func (r *Replica) Set(name string) *ORSet {
+	return &ORSet{r, name, r.lookup(name, setType).(*orSet)}
+}
+
+// Register returns the register with the name.
+func (r *Replica) Register(name string) *MVRegister {
+	return &MVRegister{r, name, r.lookup(name, registerType).(*mvRegister)}
+}
+
+// Text returns the text with the name.
+func (r *Replica) Text(name string) *RGA {
+	return &RGA{r, name, r.lookup(name, textType).(*rga)}
+}
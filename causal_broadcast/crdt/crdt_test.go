@@ -0,0 +1,200 @@
+package crdt
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+func replicas(ids ...string) []*Replica {
+	var rs []*Replica
+	for _, id := range ids {
+		n := node.New(id)
+		for _, other := range ids {
+			if other != id {
+				n.Clock.AddMember(other, 0)
+			}
+		}
+		rs = append(rs, NewReplica(n))
+	}
+	return rs
+}
+
+func must(p []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestAddWins(t *testing.T) {
+	rs := replicas("a", "b")
+	a, b := rs[0], rs[1]
+
+	b.Write(must(a.Set("s").Add("x")))
+	// a removes x while b adds it again without having seen the remove.
+	rm := must(a.Set("s").Remove("x"))
+	add := must(b.Set("s").Add("x"))
+	a.Write(add)
+	b.Write(rm)
+
+	for _, r := range rs {
+		if !r.Set("s").Contains("x") {
+			t.Errorf("expected the concurrent add to win on %s", r.node.Id)
+		}
+	}
+
+	// a has seen both adds now, removing takes both away.
+	b.Write(must(a.Set("s").Remove("x")))
+	for _, r := range rs {
+		if r.Set("s").Contains("x") {
+			t.Errorf("expected x to be gone on %s", r.node.Id)
+		}
+	}
+}
+
+func TestConcurrentRegister(t *testing.T) {
+	rs := replicas("a", "b")
+	a, b := rs[0], rs[1]
+
+	pa := must(a.Register("r").Set("from a"))
+	pb := must(b.Register("r").Set("from b"))
+	a.Write(pb)
+	b.Write(pa)
+	for _, r := range rs {
+		if vals := r.Register("r").Values(); !reflect.DeepEqual(vals, []string{"from a", "from b"}) {
+			t.Errorf("expected both writes, got %v", vals)
+		}
+	}
+
+	a.Write(must(b.Register("r").Set("merged")))
+	for _, r := range rs {
+		if vals := r.Register("r").Values(); !reflect.DeepEqual(vals, []string{"merged"}) {
+			t.Errorf("expected the merge to overwrite, got %v", vals)
+		}
+	}
+}
+
+func TestConcurrentInserts(t *testing.T) {
+	rs := replicas("a", "b")
+	a, b := rs[0], rs[1]
+
+	for i, c := range "hd" {
+		b.Write(must(a.Text("t").Insert(i, string(c))))
+	}
+	// both type between h and d at the same time.
+	pa := must(a.Text("t").Insert(1, "i"))
+	pb := must(b.Text("t").Insert(1, "ello worl"))
+	a.Write(pb)
+	b.Write(pa)
+
+	// same sums, b breaks the tie and goes first.
+	for _, r := range rs {
+		if text := r.Text("t").String(); text != "hello worlid" {
+			t.Errorf("expected hello worlid on %s, got %q", r.node.Id, text)
+		}
+	}
+
+	b.Write(must(a.Text("t").Delete(0)))
+	for _, r := range rs {
+		if text := r.Text("t").String(); text != "ello worlid" || r.Text("t").Len() != 3 {
+			t.Errorf("expected ello worlid in 3 elements on %s, got %q", r.node.Id, text)
+		}
+	}
+}
+
+func TestMixedTypes(t *testing.T) {
+	rs := replicas("a", "b")
+	a, b := rs[0], rs[1]
+	// the same name for different types on different replicas.
+	pa := must(a.Counter("x").Inc(1))
+	pb := must(b.Set("x").Add("1"))
+	a.Write(pb)
+	b.Write(pa)
+	for _, r := range rs {
+		if err := r.Err(); err == nil {
+			t.Errorf("expected an op on the wrong type to be an error on %s", r.node.Id)
+		}
+	}
+}
+
+// TestConvergence makes random concurrent operations on a few replicas and
+// delivers them in random orders. Once everything is delivered every
+// replica has to be in the same state.
+func TestConvergence(t *testing.T) {
+	type flight struct {
+		to int
+		p  []byte
+	}
+	elems := []string{"x", "y", "z"}
+	letters := []string{"a", "b", "c", "d", "e"}
+
+	for seed := int64(0); seed < 50; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		rs := replicas("n0", "n1", "n2", "n3")
+		var inFlight []flight
+
+		for ops := 0; ops < 60 || len(inFlight) > 0; {
+			if ops < 60 && (len(inFlight) == 0 || rnd.Intn(2) == 0) {
+				from := rnd.Intn(len(rs))
+				r := rs[from]
+				var p []byte
+				var err error
+				switch rnd.Intn(6) {
+				case 0:
+					p, err = r.Counter("c").Inc(rnd.Intn(10) - 5)
+				case 1:
+					p, err = r.Set("s").Add(elems[rnd.Intn(len(elems))])
+				case 2:
+					p, err = r.Set("s").Remove(elems[rnd.Intn(len(elems))])
+				case 3:
+					p, err = r.Register("r").Set(letters[rnd.Intn(len(letters))])
+				case 4:
+					p, err = r.Text("t").Insert(rnd.Intn(r.Text("t").Len()+1), letters[rnd.Intn(len(letters))])
+				case 5:
+					if l := r.Text("t").Len(); l > 0 {
+						p, err = r.Text("t").Delete(rnd.Intn(l))
+					}
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				ops++
+				if p == nil {
+					continue
+				}
+				for i := range rs {
+					if i != from {
+						inFlight = append(inFlight, flight{i, p})
+					}
+				}
+				continue
+			}
+			i := rnd.Intn(len(inFlight))
+			f := inFlight[i]
+			inFlight = append(inFlight[:i], inFlight[i+1:]...)
+			rs[f.to].Write(f.p)
+		}
+
+		first := rs[0]
+		for _, r := range rs {
+			if err := r.Err(); err != nil {
+				t.Fatalf("seed %d: %v", seed, err)
+			}
+			if r.Counter("c").Value() != first.Counter("c").Value() {
+				t.Errorf("seed %d: counters %d and %d", seed, first.Counter("c").Value(), r.Counter("c").Value())
+			}
+			if !reflect.DeepEqual(r.Set("s").Elements(), first.Set("s").Elements()) {
+				t.Errorf("seed %d: sets %v and %v", seed, first.Set("s").Elements(), r.Set("s").Elements())
+			}
+			if !reflect.DeepEqual(r.Register("r").Values(), first.Register("r").Values()) {
+				t.Errorf("seed %d: registers %v and %v", seed, first.Register("r").Values(), r.Register("r").Values())
+			}
+			if r.Text("t").String() != first.Text("t").String() {
+				t.Errorf("seed %d: texts %q and %q", seed, first.Text("t"), r.Text("t"))
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package crdt
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+// ORSet is an observed-remove set. Every add tags the element with the dot
+// of the add, and a remove takes away the tags the remover has seen. An
+// add the remover hasn't seen is concurrent with the remove and keeps its
+// tag, so the add wins.
+type ORSet struct {
+	r    *Replica
+	name string
+	s    *orSet
+}
+
+type orSet struct {
+	tags map[string]map[clock.Dot]bool
+}
+
+type setOp struct {
+	Add    string      `json:"add,omitempty"`
+	Remove string      `json:"remove,omitempty"`
+	Tags   []clock.Dot `json:"tags,omitempty"` // tags the remove takes away
+}
+
+func newORSet() *orSet { return &orSet{tags: make(map[string]map[clock.Dot]bool)} }
+
+func (s *orSet) effect(raw json.RawMessage, e *node.Event) error {
+	var op setOp
+	if err := json.Unmarshal(raw, &op); err != nil {
+		return err
+	}
+	if op.Tags != nil {
+		for _, t := range op.Tags {
+			delete(s.tags[op.Remove], t)
+		}
+		if len(s.tags[op.Remove]) == 0 {
+			delete(s.tags, op.Remove)
+		}
+		return nil
+	}
+	d, err := dotOf(e)
+	if err != nil {
+		return err
+	}
+	if s.tags[op.Add] == nil {
+		s.tags[op.Add] = make(map[clock.Dot]bool)
+	}
+	s.tags[op.Add][d] = true
+	return nil
+}
+
+// Add adds an element.
+func (s *ORSet) Add(elem string) ([]byte, error) {
+	return s.r.send(s.name, setType, setOp{Add: elem})
+}
+
+// Remove removes an element, as far as this replica has seen it added.
+// Nothing is sent when the replica doesn't have it.
+func (s *ORSet) Remove(elem string) ([]byte, error) {
+	s.r.mu.Lock()
+	var tags []clock.Dot
+	for t := range s.s.tags[elem] {
+		tags = append(tags, t)
+	}
+	s.r.mu.Unlock()
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	return s.r.send(s.name, setType, setOp{Remove: elem, Tags: tags})
+}
+
+// Contains reports whether the element is in the set.
+func (s *ORSet) Contains(elem string) bool {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	return len(s.s.tags[elem]) > 0
+}
+
+// Elements returns the elements, sorted.
+func (s *ORSet) Elements() []string {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	elems := make([]string, 0, len(s.s.tags))
+	for elem := range s.s.tags {
+		elems = append(elems, elem)
+	}
+	sort.Strings(elems)
+	return elems
+}
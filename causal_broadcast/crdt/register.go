@@ -0,0 +1,70 @@
+package crdt
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+// MVRegister is a multi-value register. A write overwrites the writes that
+// happened before it, writes that are concurrent all stay until a write
+// that has seen them comes along.
+type MVRegister struct {
+	r    *Replica
+	name string
+	m    *mvRegister
+}
+
+type mvRegister struct {
+	values []mvValue
+}
+
+type mvValue struct {
+	value string
+	clock *clock.Vector
+}
+
+type registerOp struct {
+	Value string `json:"value"`
+}
+
+func newMVRegister() *mvRegister { return &mvRegister{} }
+
+func (m *mvRegister) effect(raw json.RawMessage, e *node.Event) error {
+	var op registerOp
+	if err := json.Unmarshal(raw, &op); err != nil {
+		return err
+	}
+	c, err := e.Clock()
+	if err != nil {
+		return err
+	}
+	var keep []mvValue
+	for _, v := range m.values {
+		if v.clock.Compare(c) == clock.Concurrent {
+			keep = append(keep, v)
+		}
+	}
+	m.values = append(keep, mvValue{op.Value, c})
+	return nil
+}
+
+// Set writes the register.
+func (m *MVRegister) Set(value string) ([]byte, error) {
+	return m.r.send(m.name, registerType, registerOp{value})
+}
+
+// Values returns the values of the concurrent writes, sorted. nil before
+// the first write.
+func (m *MVRegister) Values() []string {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	var vals []string
+	for _, v := range m.m.values {
+		vals = append(vals, v.value)
+	}
+	sort.Strings(vals)
+	return vals
+}
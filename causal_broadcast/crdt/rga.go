@@ -0,0 +1,177 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+// RGA is a replicated growable array, a sequence for collaborative text.
+//
+// Every element is named by the dot of its insert, and an insert says
+// which element it goes after instead of where. Inserts after the same
+// element are concurrent, they're put in order by their timestamps, the
+// later one first. Deleted elements stay around as tombstones so later
+// inserts can still find them.
+type RGA struct {
+	r    *Replica
+	name string
+	a    *rga
+}
+
+type rga struct {
+	elems []*rgaElem
+}
+
+type rgaElem struct {
+	id      clock.Dot
+	ts      stamp
+	value   string
+	deleted bool
+}
+
+// stamp orders the inserts. The sum of a vector clock goes up with every
+// message seen, so an insert that saw another one has a bigger sum, the
+// node breaks the ties between concurrent ones.
+type stamp struct {
+	sum  int
+	node string
+}
+
+func (s stamp) after(o stamp) bool {
+	if s.sum != o.sum {
+		return s.sum > o.sum
+	}
+	return s.node > o.node
+}
+
+type rgaOp struct {
+	After  *clock.Dot `json:"after,omitempty"` // nil for the start of the text
+	Insert string     `json:"insert,omitempty"`
+	Delete *clock.Dot `json:"delete,omitempty"`
+}
+
+func newRGA() *rga { return &rga{} }
+
+func (a *rga) find(id clock.Dot) int {
+	for i, el := range a.elems {
+		if el.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a *rga) effect(raw json.RawMessage, e *node.Event) error {
+	var op rgaOp
+	if err := json.Unmarshal(raw, &op); err != nil {
+		return err
+	}
+	if op.Delete != nil {
+		i := a.find(*op.Delete)
+		if i < 0 {
+			return fmt.Errorf("deleting %s which isn't there", op.Delete)
+		}
+		a.elems[i].deleted = true
+		return nil
+	}
+
+	c, err := e.Clock()
+	if err != nil {
+		return err
+	}
+	sum := 0
+	for _, val := range c.Values() {
+		sum += val
+	}
+	el := &rgaElem{id: clock.Dot{Id: e.Id, Seq: c.Get()}, ts: stamp{sum, e.Id}, value: op.Insert}
+
+	i := 0
+	if op.After != nil {
+		if i = a.find(*op.After); i < 0 {
+			return fmt.Errorf("inserting after %s which isn't there", op.After)
+		}
+		i++
+	}
+	// skip the concurrent inserts that go first, and whatever went in
+	// after them, which all came later still.
+	for i < len(a.elems) && a.elems[i].ts.after(el.ts) {
+		i++
+	}
+	a.elems = append(a.elems, nil)
+	copy(a.elems[i+1:], a.elems[i:])
+	a.elems[i] = el
+	return nil
+}
+
+// visible returns the element at a position in the text, nil when pos is
+// the end of it.
+func (a *rga) visible(pos int) *rgaElem {
+	for _, el := range a.elems {
+		if el.deleted {
+			continue
+		}
+		if pos == 0 {
+			return el
+		}
+		pos--
+	}
+	return nil
+}
+
+// Insert inserts value at a position of the text, 0 is the start.
+func (t *RGA) Insert(pos int, value string) ([]byte, error) {
+	op := rgaOp{Insert: value}
+	if pos > 0 {
+		t.r.mu.Lock()
+		el := t.a.visible(pos - 1)
+		t.r.mu.Unlock()
+		if el == nil {
+			return nil, fmt.Errorf("crdt: insert at %d is past the end of %s", pos, t.name)
+		}
+		id := el.id
+		op.After = &id
+	}
+	return t.r.send(t.name, textType, op)
+}
+
+// Delete deletes the element at a position of the text.
+func (t *RGA) Delete(pos int) ([]byte, error) {
+	t.r.mu.Lock()
+	el := t.a.visible(pos)
+	t.r.mu.Unlock()
+	if el == nil {
+		return nil, fmt.Errorf("crdt: delete at %d is past the end of %s", pos, t.name)
+	}
+	id := el.id
+	return t.r.send(t.name, textType, rgaOp{Delete: &id})
+}
+
+// Len returns the number of elements in the text.
+func (t *RGA) Len() int {
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	n := 0
+	for _, el := range t.a.elems {
+		if !el.deleted {
+			n++
+		}
+	}
+	return n
+}
+
+// String returns the text.
+func (t *RGA) String() string {
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	var b strings.Builder
+	for _, el := range t.a.elems {
+		if !el.deleted {
+			b.WriteString(el.value)
+		}
+	}
+	return b.String()
+}
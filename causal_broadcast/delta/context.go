@@ -0,0 +1,139 @@
+package delta
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+)
+
+// CausalContext is the set of events a state knows about. Most of it is
+// every event of a node up to some count, that goes in a vector clock, and
+// the few that came out of order sit in the dot cloud until the gap before
+// them fills in.
+type CausalContext struct {
+	vv    *clock.Vector
+	cloud map[clock.Dot]bool
+}
+
+// NewContext returns an empty context kept by the node with the id.
+func NewContext(id string) *CausalContext {
+	return &CausalContext{vv: clock.New(id), cloud: make(map[clock.Dot]bool)}
+}
+
+// Contains reports whether the context knows about the event.
+func (c *CausalContext) Contains(d clock.Dot) bool {
+	return d.Seq <= c.vv.GetMember(d.Id) || c.cloud[d]
+}
+
+// Next returns the next event of a node. It isn't added to the context.
+func (c *CausalContext) Next(id string) clock.Dot {
+	return clock.Dot{Id: id, Seq: c.vv.GetMember(id) + 1}
+}
+
+// Add adds an event to the context.
+func (c *CausalContext) Add(d clock.Dot) {
+	if c.Contains(d) {
+		return
+	}
+	c.cloud[d] = true
+	c.compact()
+}
+
+// Join adds everything the other context knows about.
+func (c *CausalContext) Join(o *CausalContext) {
+	c.vv.Merge(o.vv)
+	for d := range o.cloud {
+		c.cloud[d] = true
+	}
+	c.compact()
+}
+
+// compact moves the dots in the cloud that follow on from the vector into
+// it, and drops the ones it already has.
+func (c *CausalContext) compact() {
+	for progress := true; progress; {
+		progress = false
+		for d := range c.cloud {
+			switch n := c.vv.GetMember(d.Id); {
+			case d.Seq <= n:
+				delete(c.cloud, d)
+			case d.Seq == n+1:
+				c.vv.AddMember(d.Id, d.Seq)
+				delete(c.cloud, d)
+				progress = true
+			}
+		}
+	}
+}
+
+// coveredBy reports whether the other context knows about every event
+// this one does.
+func (c *CausalContext) coveredBy(o *CausalContext) bool {
+	for id, n := range c.vv.Values() {
+		for seq := o.vv.GetMember(id) + 1; seq <= n; seq++ {
+			if !o.cloud[clock.Dot{Id: id, Seq: seq}] {
+				return false
+			}
+		}
+	}
+	for d := range c.cloud {
+		if !o.Contains(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// Vector returns the part of the context without gaps.
+func (c *CausalContext) Vector() *clock.Vector {
+	return c.vv.Copy()
+}
+
+// Cloud returns the events past the gaps, sorted.
+func (c *CausalContext) Cloud() []clock.Dot {
+	dots := make([]clock.Dot, 0, len(c.cloud))
+	for d := range c.cloud {
+		dots = append(dots, d)
+	}
+	sortDots(dots)
+	return dots
+}
+
+func (c *CausalContext) copy() *CausalContext {
+	cc := &CausalContext{vv: c.vv.Copy(), cloud: make(map[clock.Dot]bool, len(c.cloud))}
+	for d := range c.cloud {
+		cc.cloud[d] = true
+	}
+	return cc
+}
+
+type contextJson struct {
+	Vector *clock.Vector `json:"vv"`
+	Cloud  []clock.Dot   `json:"cloud,omitempty"`
+}
+
+func (c *CausalContext) MarshalJSON() ([]byte, error) {
+	return json.Marshal(contextJson{c.vv, c.Cloud()})
+}
+
+func (c *CausalContext) UnmarshalJSON(p []byte) error {
+	cj := contextJson{Vector: clock.New("")}
+	if err := json.Unmarshal(p, &cj); err != nil {
+		return err
+	}
+	c.vv, c.cloud = cj.Vector, make(map[clock.Dot]bool, len(cj.Cloud))
+	for _, d := range cj.Cloud {
+		c.cloud[d] = true
+	}
+	return nil
+}
+
+func sortDots(dots []clock.Dot) {
+	sort.Slice(dots, func(i, j int) bool {
+		if dots[i].Id != dots[j].Id {
+			return dots[i].Id < dots[j].Id
+		}
+		return dots[i].Seq < dots[j].Seq
+	})
+}
@@ -0,0 +1,240 @@
+// Package delta has state based CRDTs that ship deltas.
+//
+// A state based CRDT replicates by sending its whole state around and
+// joining whatever it gets into its own. Joins don't care about order or
+// how many times they happen, so any channel does, lossy, reordering and
+// duplicating ones too. The catch is shipping the whole state every time.
+// A delta mutator returns just the part of the state an update changed,
+// which joins like any other state, and a replica ships those instead,
+// falling back on the whole state for a peer that missed too much.
+//
+// The states here are dot stores: every value is tagged with the event
+// that wrote it, and a causal context says which events the state knows
+// about. A state that knows about an event but doesn't have its value
+// anymore knows the value was overwritten or removed, that's how a join
+// tells a removal from a value it just hasn't heard of yet.
+package delta
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+)
+
+// State is a map of keys to the values written to them, every value
+// tagged with its event, and the causal context of all of it.
+type State struct {
+	dots map[string]map[clock.Dot]string
+	ctx  *CausalContext
+}
+
+func newState(id string) *State {
+	return &State{dots: make(map[string]map[clock.Dot]string), ctx: NewContext(id)}
+}
+
+// Context returns the causal context of the state.
+func (s *State) Context() *CausalContext { return s.ctx }
+
+// Join joins another state into this one. A value stays if both states
+// have it, or if the state without it never knew about it.
+func (s *State) Join(o *State) {
+	keys := make(map[string]bool)
+	for k := range s.dots {
+		keys[k] = true
+	}
+	for k := range o.dots {
+		keys[k] = true
+	}
+	for k := range keys {
+		a, b := s.dots[k], o.dots[k]
+		joined := make(map[clock.Dot]string)
+		for d, v := range a {
+			if _, ok := b[d]; ok || !o.ctx.Contains(d) {
+				joined[d] = v
+			}
+		}
+		for d, v := range b {
+			if _, ok := a[d]; !ok && !s.ctx.Contains(d) {
+				joined[d] = v
+			}
+		}
+		if len(joined) == 0 {
+			delete(s.dots, k)
+			continue
+		}
+		s.dots[k] = joined
+	}
+	s.ctx.Join(o.ctx)
+}
+
+// coveredBy reports whether joining the state into the other one would
+// change nothing. Everything in a state is tagged with an event in its
+// context, so the other one has to know all the events, and not have a
+// value this one knows about but removed.
+func (s *State) coveredBy(o *State) bool {
+	if !s.ctx.coveredBy(o.ctx) {
+		return false
+	}
+	for k, vals := range o.dots {
+		for d := range vals {
+			if _, ok := s.dots[k][d]; !ok && s.ctx.Contains(d) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *State) copy() *State {
+	c := &State{dots: make(map[string]map[clock.Dot]string, len(s.dots)), ctx: s.ctx.copy()}
+	for k, vals := range s.dots {
+		c.dots[k] = make(map[clock.Dot]string, len(vals))
+		for d, v := range vals {
+			c.dots[k][d] = v
+		}
+	}
+	return c
+}
+
+// write returns the delta of writing a value to a key on the node with
+// the id, the value tagged with the next event of the node and the old
+// values of the key in the context so they go away.
+func (s *State) write(id, key, value string) *State {
+	d := newState(id)
+	next := s.ctx.Next(id)
+	d.dots[key] = map[clock.Dot]string{next: value}
+	d.ctx.Add(next)
+	for old := range s.dots[key] {
+		d.ctx.Add(old)
+	}
+	return d
+}
+
+// remove returns the delta of removing a key, only the values of the key
+// in the context.
+func (s *State) remove(id, key string) *State {
+	d := newState(id)
+	for old := range s.dots[key] {
+		d.ctx.Add(old)
+	}
+	return d
+}
+
+func (s *State) values(key string) []string {
+	var vals []string
+	for _, v := range s.dots[key] {
+		vals = append(vals, v)
+	}
+	sort.Strings(vals)
+	return vals
+}
+
+func (s *State) keys() []string {
+	keys := make([]string, 0, len(s.dots))
+	for k := range s.dots {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type entryJson struct {
+	Key   string    `json:"key"`
+	Dot   clock.Dot `json:"dot"`
+	Value string    `json:"value,omitempty"`
+}
+
+type stateJson struct {
+	Entries []entryJson    `json:"entries,omitempty"`
+	Context *CausalContext `json:"context"`
+}
+
+func (s *State) MarshalJSON() ([]byte, error) {
+	sj := stateJson{Context: s.ctx}
+	for _, k := range s.keys() {
+		var dots []clock.Dot
+		for d := range s.dots[k] {
+			dots = append(dots, d)
+		}
+		sortDots(dots)
+		for _, d := range dots {
+			sj.Entries = append(sj.Entries, entryJson{k, d, s.dots[k][d]})
+		}
+	}
+	return json.Marshal(sj)
+}
+
+func (s *State) UnmarshalJSON(p []byte) error {
+	sj := stateJson{Context: NewContext("")}
+	if err := json.Unmarshal(p, &sj); err != nil {
+		return err
+	}
+	s.dots, s.ctx = make(map[string]map[clock.Dot]string), sj.Context
+	for _, e := range sj.Entries {
+		if s.dots[e.Key] == nil {
+			s.dots[e.Key] = make(map[clock.Dot]string)
+		}
+		s.dots[e.Key][e.Dot] = e.Value
+	}
+	return nil
+}
+
+// AWSet is an add-wins set, the state of a replica seen as one. Removing
+// an element only removes the adds the replica knows about, an add it
+// didn't know about wins.
+type AWSet struct{ r *Replica }
+
+// Add adds an element and returns the delta.
+func (a AWSet) Add(elem string) *State {
+	return a.r.mutate(func(s *State) *State { return s.write(a.r.Id, elem, "") })
+}
+
+// Remove removes an element and returns the delta.
+func (a AWSet) Remove(elem string) *State {
+	return a.r.mutate(func(s *State) *State { return s.remove(a.r.Id, elem) })
+}
+
+// Contains reports whether the element is in the set.
+func (a AWSet) Contains(elem string) bool {
+	a.r.mu.Lock()
+	defer a.r.mu.Unlock()
+	return len(a.r.state.dots[elem]) > 0
+}
+
+// Elements returns the elements of the set, sorted.
+func (a AWSet) Elements() []string {
+	a.r.mu.Lock()
+	defer a.r.mu.Unlock()
+	return a.r.state.keys()
+}
+
+// RegisterMap is a map of multi-value registers, the state of a replica
+// seen as one. A put overwrites the values the replica knows about,
+// concurrent puts to a key all stay until a put that knows about them.
+// Deleting a key is add-wins the same way.
+type RegisterMap struct{ r *Replica }
+
+// Put writes a value to a key and returns the delta.
+func (m RegisterMap) Put(key, value string) *State {
+	return m.r.mutate(func(s *State) *State { return s.write(m.r.Id, key, value) })
+}
+
+// Delete deletes a key and returns the delta.
+func (m RegisterMap) Delete(key string) *State {
+	return m.r.mutate(func(s *State) *State { return s.remove(m.r.Id, key) })
+}
+
+// Get returns the values of a key, sorted.
+func (m RegisterMap) Get(key string) []string {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	return m.r.state.values(key)
+}
+
+// Keys returns the keys in the map, sorted.
+func (m RegisterMap) Keys() []string {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	return m.r.state.keys()
+}
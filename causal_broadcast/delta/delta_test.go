@@ -0,0 +1,238 @@
+package delta
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+)
+
+func TestContext(t *testing.T) {
+	c := NewContext("a")
+	c.Add(clock.Dot{Id: "b", Seq: 2})
+	if c.Contains(clock.Dot{Id: "b", Seq: 1}) || !c.Contains(clock.Dot{Id: "b", Seq: 2}) {
+		t.Errorf("expected only b:2 in %v %v", c.Vector(), c.Cloud())
+	}
+	o := NewContext("b")
+	o.Add(clock.Dot{Id: "b", Seq: 1})
+	c.Join(o)
+	if c.Vector().GetMember("b") != 2 || len(c.Cloud()) != 0 {
+		t.Errorf("expected the gap to fill in, got %v %v", c.Vector(), c.Cloud())
+	}
+	if c.Next("b") != (clock.Dot{Id: "b", Seq: 3}) {
+		t.Errorf("expected b:3 next, got %v", c.Next("b"))
+	}
+
+	p, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := NewContext("")
+	if err := json.Unmarshal(p, back); err != nil {
+		t.Fatal(err)
+	}
+	if !back.coveredBy(c) || !c.coveredBy(back) {
+		t.Errorf("expected %s back", p)
+	}
+}
+
+// exchange hands messages over until there are none left.
+func exchange(rs map[string]*Replica, msgs []Message) {
+	for len(msgs) > 0 {
+		m := msgs[0]
+		msgs = append(msgs[1:], rs[m.To].Receive(m)...)
+	}
+}
+
+// replicas makes replicas of the ids that are each other's peers, made
+// with NewSetReplica or NewMapReplica.
+func replicas(newReplica func(string, ...string) *Replica, ids ...string) map[string]*Replica {
+	rs := make(map[string]*Replica)
+	for _, id := range ids {
+		var peers []string
+		for _, p := range ids {
+			if p != id {
+				peers = append(peers, p)
+			}
+		}
+		rs[id] = newReplica(id, peers...)
+	}
+	return rs
+}
+
+func TestAddWins(t *testing.T) {
+	rs := replicas(NewSetReplica, "a", "b")
+	a, b := rs["a"], rs["b"]
+	a.Set().Add("x")
+	exchange(rs, a.Gossip())
+
+	// a removes x while b adds it again.
+	a.Set().Remove("x")
+	b.Set().Add("x")
+	exchange(rs, append(a.Gossip(), b.Gossip()...))
+	for id, r := range rs {
+		if !r.Set().Contains("x") {
+			t.Errorf("expected the concurrent add to win on %s", id)
+		}
+	}
+	// what a and b learned from each other goes back once more before
+	// everything is acked.
+	exchange(rs, append(a.Gossip(), b.Gossip()...))
+	if a.Buffered() != 0 || b.Buffered() != 0 {
+		t.Errorf("expected acked deltas to be dropped, %d and %d left", a.Buffered(), b.Buffered())
+	}
+}
+
+func TestReplicaIsOneObject(t *testing.T) {
+	r := NewSetReplica("a")
+	r.Set().Add("k")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected the map of a set replica to panic, it would see k as a key")
+		}
+	}()
+	r.Map().Get("k")
+}
+
+func TestRegisterMap(t *testing.T) {
+	rs := replicas(NewMapReplica, "a", "b")
+	a, b := rs["a"], rs["b"]
+	a.Map().Put("k", "from a")
+	b.Map().Put("k", "from b")
+	exchange(rs, append(a.Gossip(), b.Gossip()...))
+	for id, r := range rs {
+		if vals := r.Map().Get("k"); !reflect.DeepEqual(vals, []string{"from a", "from b"}) {
+			t.Errorf("expected both values on %s, got %v", id, vals)
+		}
+	}
+
+	b.Map().Put("k", "merged")
+	a.Map().Put("other", "1")
+	exchange(rs, append(a.Gossip(), b.Gossip()...))
+	for id, r := range rs {
+		if vals := r.Map().Get("k"); !reflect.DeepEqual(vals, []string{"merged"}) {
+			t.Errorf("expected the merge to overwrite on %s, got %v", id, vals)
+		}
+		if keys := r.Map().Keys(); !reflect.DeepEqual(keys, []string{"k", "other"}) {
+			t.Errorf("expected both keys on %s, got %v", id, keys)
+		}
+	}
+}
+
+func TestDeltasAreSmall(t *testing.T) {
+	rs := replicas(NewMapReplica, "a", "b")
+	a := rs["a"]
+	for i := 0; i < 50; i++ {
+		a.Map().Put(fmt.Sprint(i), "v")
+	}
+	exchange(rs, a.Gossip())
+
+	a.Map().Put("0", "w")
+	msgs := a.Gossip()
+	if len(msgs) != 1 || len(msgs[0].State.dots) != 1 {
+		t.Fatalf("expected a single one entry delta, got %v", msgs)
+	}
+	exchange(rs, msgs)
+	if vals := rs["b"].Map().Get("0"); !reflect.DeepEqual(vals, []string{"w"}) {
+		t.Errorf("expected the delta to overwrite, got %v", vals)
+	}
+}
+
+// network loses, duplicates and reorders messages, and sends them as
+// json so nothing is shared between replicas.
+type network struct {
+	rnd      *rand.Rand
+	loss     int // percent
+	inFlight [][]byte
+}
+
+func (n *network) send(t *testing.T, msgs []Message) {
+	for _, m := range msgs {
+		p, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for copies := 1 + n.rnd.Intn(2); copies > 0; copies-- {
+			if n.rnd.Intn(100) >= n.loss {
+				n.inFlight = append(n.inFlight, p)
+			}
+		}
+	}
+}
+
+func (n *network) deliver(t *testing.T, rs map[string]*Replica) {
+	i := n.rnd.Intn(len(n.inFlight))
+	p := n.inFlight[i]
+	n.inFlight = append(n.inFlight[:i], n.inFlight[i+1:]...)
+	var m Message
+	if err := json.Unmarshal(p, &m); err != nil {
+		t.Fatal(err)
+	}
+	n.send(t, rs[m.To].Receive(m))
+}
+
+func TestConvergence(t *testing.T) {
+	ids := []string{"a", "b", "c", "d"}
+	keys := []string{"x", "y", "z"}
+	view := func(r *Replica) string {
+		s := ""
+		for _, k := range keys {
+			s += fmt.Sprint(r.Map().Get(k))
+		}
+		return s
+	}
+
+	for seed := int64(0); seed < 30; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		rs := replicas(NewMapReplica, ids...)
+		if seed%2 == 1 {
+			for _, r := range rs {
+				r.MaxDeltas = 3
+			}
+		}
+		net := &network{rnd: rnd, loss: 30}
+
+		for step := 0; step < 400; step++ {
+			r := rs[ids[rnd.Intn(len(ids))]]
+			switch x := rnd.Intn(10); {
+			case step < 200 && x < 3:
+				r.Map().Put(keys[rnd.Intn(len(keys))], fmt.Sprint(step))
+			case step < 200 && x == 3:
+				r.Map().Delete(keys[rnd.Intn(len(keys))])
+			case x < 6:
+				net.send(t, r.Gossip())
+			default:
+				if len(net.inFlight) > 0 {
+					net.deliver(t, rs)
+				}
+			}
+		}
+
+		// the network gets better, gossip until everyone has acked.
+		net.loss = 0
+		for rounds := 0; ; rounds++ {
+			if rounds > 100 {
+				t.Fatalf("seed %d: replicas never caught up", seed)
+			}
+			for _, id := range ids {
+				net.send(t, rs[id].Gossip())
+			}
+			if len(net.inFlight) == 0 {
+				break
+			}
+			for len(net.inFlight) > 0 {
+				net.deliver(t, rs)
+			}
+		}
+
+		want := view(rs["a"])
+		for _, id := range ids {
+			if got := view(rs[id]); got != want {
+				t.Errorf("seed %d: a has %s and %s has %s", seed, want, id, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package delta
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Anti-entropy is the replicas making sure everyone ends up with
+// everything, the deltas only make it cheaper:
+//
+// -> every delta a replica makes or learns about goes in its buffer with
+//    the next sequence number.
+// -> Gossip sends every peer the join of the deltas it hasn't acked, or
+//    the whole state when the buffer doesn't go back far enough.
+// -> a replica joins what it gets and acks the sequence number it came
+//    with. what was news goes in its own buffer so it travels on.
+// -> deltas every peer acked are dropped from the buffer.
+//
+// Nothing is resent on a timer, whatever is lost goes again on the next
+// Gossip until it's acked, so channels can lose, reorder and duplicate
+// messages all they like.
+
+// Message is what replicas send each other.
+type Message struct {
+	From, To string
+	Ack      bool   `json:",omitempty"`
+	Seq      int    // sequence number the state brings the peer up to, or the one acked
+	State    *State `json:",omitempty"`
+}
+
+// Replica is a copy of a state and what it takes to keep it in sync with
+// the peers. The state is one object, a set or a map, not both, both see
+// the keys of the state as theirs.
+type Replica struct {
+	Id   string
+	kind string // setKind or mapKind, fixed when the replica is made
+
+	// MaxDeltas is how many deltas the buffer holds before the oldest are
+	// dropped, 0 for no limit. Peers that fall behind the buffer get the
+	// whole state.
+	MaxDeltas int
+
+	mu     sync.Mutex
+	peers  []string
+	state  *State
+	seq    int            // sequence number of the next delta
+	deltas map[int]*State // buffer of deltas by sequence number
+	acks   map[string]int // what every peer acked
+}
+
+// kinds of objects a replica holds.
+const (
+	setKind = "set"
+	mapKind = "map"
+)
+
+// NewSetReplica makes a replica of an empty add-wins set.
+func NewSetReplica(id string, peers ...string) *Replica {
+	return newReplica(setKind, id, peers)
+}
+
+// NewMapReplica makes a replica of an empty map of registers.
+func NewMapReplica(id string, peers ...string) *Replica {
+	return newReplica(mapKind, id, peers)
+}
+
+func newReplica(kind, id string, peers []string) *Replica {
+	r := &Replica{
+		Id:     id,
+		kind:   kind,
+		peers:  append([]string(nil), peers...),
+		state:  newState(id),
+		deltas: make(map[int]*State),
+		acks:   make(map[string]int),
+	}
+	sort.Strings(r.peers)
+	return r
+}
+
+// Set returns the state seen as an add-wins set. It panics on a replica
+// of a map, that's a bug in the caller.
+func (r *Replica) Set() AWSet {
+	r.must(setKind)
+	return AWSet{r}
+}
+
+// Map returns the state seen as a map of registers. It panics on a
+// replica of a set.
+func (r *Replica) Map() RegisterMap {
+	r.must(mapKind)
+	return RegisterMap{r}
+}
+
+func (r *Replica) must(kind string) {
+	if r.kind != kind {
+		panic(fmt.Sprintf("delta: %s is a replica of a %s, not a %s", r.Id, r.kind, kind))
+	}
+}
+
+// State returns a copy of the state.
+func (r *Replica) State() *State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.copy()
+}
+
+// Buffered returns how many deltas the replica is holding on to.
+func (r *Replica) Buffered() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.deltas)
+}
+
+// mutate makes a delta from the state, joins it and buffers it.
+func (r *Replica) mutate(m func(*State) *State) *State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := m(r.state)
+	r.state.Join(d)
+	r.buffer(d)
+	return d.copy()
+}
+
+// buffer puts a delta in the buffer. The caller holds the lock.
+func (r *Replica) buffer(d *State) {
+	r.deltas[r.seq] = d
+	r.seq++
+	if r.MaxDeltas > 0 {
+		for seq := r.seq - r.MaxDeltas - 1; seq >= 0; seq-- {
+			if _, ok := r.deltas[seq]; !ok {
+				break
+			}
+			delete(r.deltas, seq)
+		}
+	}
+}
+
+// Gossip returns the messages to send every peer that's behind.
+func (r *Replica) Gossip() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var msgs []Message
+	for _, p := range r.peers {
+		acked := r.acks[p]
+		if acked >= r.seq {
+			continue
+		}
+		var s *State
+		for seq := acked; seq < r.seq; seq++ {
+			d, ok := r.deltas[seq]
+			if !ok {
+				s = nil
+				break
+			}
+			if s == nil {
+				s = d.copy()
+			} else {
+				s.Join(d)
+			}
+		}
+		if s == nil {
+			s = r.state.copy()
+		}
+		msgs = append(msgs, Message{From: r.Id, To: p, Seq: r.seq, State: s})
+	}
+	return msgs
+}
+
+// Receive handles a message from a peer and returns what to send back.
+func (r *Replica) Receive(m Message) []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m.Ack {
+		if m.Seq > r.acks[m.From] {
+			r.acks[m.From] = m.Seq
+		}
+		r.collect()
+		return nil
+	}
+	if m.State == nil {
+		return nil
+	}
+	if !m.State.coveredBy(r.state) {
+		r.state.Join(m.State)
+		r.buffer(m.State.copy())
+	}
+	return []Message{{From: r.Id, To: m.From, Ack: true, Seq: m.Seq}}
+}
+
+// collect drops the deltas every peer has acked. The caller holds the
+// lock.
+func (r *Replica) collect() {
+	low := r.seq
+	for _, p := range r.peers {
+		if r.acks[p] < low {
+			low = r.acks[p]
+		}
+	}
+	for seq := range r.deltas {
+		if seq < low {
+			delete(r.deltas, seq)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package dynamo
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+)
+
+// MergeFunc reconciles the siblings of a key into one value. It should
+// come to the same value whatever order the siblings are in.
+type MergeFunc func(siblings []string) string
+
+// UnionMerge treats values as comma separated sets and returns their
+// union, sorted. It's the shopping cart: nothing anyone added gets lost,
+// though something taken out may come back.
+func UnionMerge(siblings []string) string {
+	seen := make(map[string]bool)
+	var items []string
+	for _, s := range siblings {
+		for _, item := range strings.Split(s, ",") {
+			if item != "" && !seen[item] {
+				seen[item] = true
+				items = append(items, item)
+			}
+		}
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// Client reads and writes a cluster, keeping the context of every key it
+// read so its writes overwrite what it saw.
+type Client struct {
+	c     *Cluster
+	merge MergeFunc
+
+	mu  sync.Mutex
+	ctx map[string]*clock.Vector
+}
+
+// NewClient returns a client of the cluster, UnionMerge when merge is nil.
+func NewClient(c *Cluster, merge MergeFunc) *Client {
+	if merge == nil {
+		merge = UnionMerge
+	}
+	return &Client{c: c, merge: merge, ctx: make(map[string]*clock.Vector)}
+}
+
+// Get reads a key, merging the siblings if there's more than one. false
+// when the key has no value.
+func (cl *Client) Get(key string) (string, bool, error) {
+	r, err := cl.c.Get(key)
+	if err != nil {
+		return "", false, err
+	}
+	cl.mu.Lock()
+	cl.ctx[key] = r.Context
+	cl.mu.Unlock()
+
+	switch len(r.Values) {
+	case 0:
+		return "", false, nil
+	case 1:
+		return r.Values[0], true, nil
+	}
+	return cl.merge(r.Values), true, nil
+}
+
+// Put writes a key, overwriting whatever the last Get of it saw. The
+// siblings that Get merged are gone once the put goes through.
+func (cl *Client) Put(key, value string) error {
+	cl.mu.Lock()
+	ctx := cl.ctx[key]
+	cl.mu.Unlock()
+	return cl.c.Put(key, value, ctx)
+}
+
+// Update reads a key, changes it and writes it back.
+func (cl *Client) Update(key string, change func(value string, ok bool) string) error {
+	value, ok, err := cl.Get(key)
+	if err != nil {
+		return err
+	}
+	return cl.Put(key, change(value, ok))
+}
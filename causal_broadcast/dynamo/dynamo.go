@@ -0,0 +1,325 @@
+// Package dynamo is a Dynamo style replicated store, all in one process.
+//
+// Keys are spread over the nodes with consistent hashing, every key lives
+// on the first N nodes after it on the ring, its preference list. A put
+// is taken by a node on the list and counts once W replicas stored it, a
+// get asks the replicas and needs R of them to answer. Values carry
+// dotted version vectors from the clock package, writes that didn't see
+// each other come back as siblings and the client merges them.
+//
+// Nodes fail and come back. While a node on the preference list is down
+// the next node along the ring stands in for it and keeps the writes as
+// hints, handing them over once the node is back. Replicas that missed
+// writes anyway are brought up to date when a read notices, read repair.
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+)
+
+// ErrQuorum is returned when fewer replicas than the quorum answered. A
+// put that fails this way may still have been stored on some of them.
+var ErrQuorum = errors.New("dynamo: not enough replicas")
+
+// Config is the shape of a cluster.
+type Config struct {
+	N, R, W int
+	VNodes  int // points on the ring per node
+}
+
+// node is a storage node.
+type node struct {
+	id   string
+	up   bool
+	data map[string][]clock.Version
+
+	// writes kept for nodes that were down, by node and key.
+	hints map[string]map[string][]clock.Version
+
+	// last dot the node made for a key. it can't go by the versions it
+	// has, it may have handed them all off.
+	issued map[string]int
+}
+
+func newNode(id string) *node {
+	return &node{
+		id:     id,
+		up:     true,
+		data:   make(map[string][]clock.Version),
+		hints:  make(map[string]map[string][]clock.Version),
+		issued: make(map[string]int),
+	}
+}
+
+// read returns what the node has for a key, hints included.
+func (n *node) read(key string) []clock.Version {
+	vs := n.data[key]
+	for _, keys := range n.hints {
+		vs = clock.Sync(vs, keys[key])
+	}
+	return vs
+}
+
+// store merges versions of a key into the node, as a hint for another
+// node when hint isn't empty.
+func (n *node) store(key string, vs []clock.Version, hint string) {
+	if hint == "" {
+		n.data[key] = clock.Sync(n.data[key], vs)
+		return
+	}
+	if n.hints[hint] == nil {
+		n.hints[hint] = make(map[string][]clock.Version)
+	}
+	n.hints[hint][key] = clock.Sync(n.hints[hint][key], vs)
+}
+
+// write makes a new version of a key on the node, the node coordinating
+// the put. It's clock.Update with the dot coming from issued.
+func (n *node) write(key string, ctx *clock.Vector, value string) []clock.Version {
+	vs := n.read(key)
+	past := make(map[string]int)
+	if ctx != nil {
+		past = ctx.Values()
+	}
+	seq := n.issued[key]
+	if s := clock.Join(vs).GetMember(n.id); s > seq {
+		seq = s
+	}
+	if past[n.id] > seq {
+		seq = past[n.id]
+	}
+	n.issued[key] = seq + 1
+	w := clock.Version{Value: value, Clock: clock.DVV{Dot: clock.Dot{Id: n.id, Seq: seq + 1}, Past: past}}
+	return clock.Sync(vs, []clock.Version{w})
+}
+
+// Cluster is the nodes and the ring.
+type Cluster struct {
+	cfg  Config
+	ring *Ring
+
+	mu    sync.Mutex
+	nodes map[string]*node
+}
+
+// New makes a cluster of the nodes, every id once.
+func New(cfg Config, ids ...string) (*Cluster, error) {
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return nil, fmt.Errorf("dynamo: node %s is there twice", id)
+		}
+		seen[id] = true
+	}
+	if cfg.N < 1 || cfg.N > len(ids) {
+		return nil, fmt.Errorf("dynamo: N is %d with %d nodes", cfg.N, len(ids))
+	}
+	if cfg.R < 1 || cfg.R > cfg.N || cfg.W < 1 || cfg.W > cfg.N {
+		return nil, fmt.Errorf("dynamo: R and W have to be between 1 and N, got %d and %d", cfg.R, cfg.W)
+	}
+	c := &Cluster{cfg: cfg, ring: NewRing(cfg.VNodes, ids...), nodes: make(map[string]*node)}
+	for _, id := range ids {
+		c.nodes[id] = newNode(id)
+	}
+	return c, nil
+}
+
+// PreferenceList returns the nodes that hold a key.
+func (c *Cluster) PreferenceList(key string) []string {
+	return c.ring.PreferenceList(key, c.cfg.N)
+}
+
+// Fail takes a node down. It keeps what it stored.
+func (c *Cluster) Fail(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.nodes[id]; ok {
+		n.up = false
+	}
+}
+
+// Recover brings a node back up. The hints for it are handed over on the
+// next Handoff.
+func (c *Cluster) Recover(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.nodes[id]; ok {
+		n.up = true
+	}
+}
+
+// Down returns the nodes that are down, sorted.
+func (c *Cluster) Down() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ids []string
+	for id, n := range c.nodes {
+		if !n.up {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// target is a node asked to hold a key, for another node when hint is
+// set.
+type target struct {
+	n    *node
+	hint string
+}
+
+// targets returns the nodes that hold a key right now, the ones on the
+// preference list that are up and a stand-in from further along the ring
+// for every one that's down. The caller holds the lock.
+func (c *Cluster) targets(key string) []target {
+	walk := c.ring.Walk(key)
+	var ts []target
+	spare := c.cfg.N
+	for _, id := range walk[:c.cfg.N] {
+		if n := c.nodes[id]; n.up {
+			ts = append(ts, target{n, ""})
+			continue
+		}
+		for spare < len(walk) && !c.nodes[walk[spare]].up {
+			spare++
+		}
+		if spare < len(walk) {
+			ts = append(ts, target{c.nodes[walk[spare]], id})
+			spare++
+		}
+	}
+	return ts
+}
+
+// Result is what a get returns.
+type Result struct {
+	Values  []string      // values of the siblings, sorted
+	Context *clock.Vector // what to put with the next write of the key
+}
+
+// Get reads a key. The replicas that answer with less than the others
+// are repaired on the way.
+func (c *Cluster) Get(key string) (Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ts := c.targets(key)
+	if len(ts) < c.cfg.R {
+		return Result{}, fmt.Errorf("%w: %d of %d answered the read", ErrQuorum, len(ts), c.cfg.R)
+	}
+	var merged []clock.Version
+	answers := make([][]clock.Version, len(ts))
+	for i, t := range ts {
+		answers[i] = t.n.read(key)
+		merged = clock.Sync(merged, answers[i])
+	}
+	for i, t := range ts {
+		if !sameVersions(answers[i], merged) {
+			t.n.store(key, merged, t.hint)
+		}
+	}
+
+	var vals []string
+	for _, v := range merged {
+		vals = append(vals, v.Value)
+	}
+	sort.Strings(vals)
+	return Result{Values: vals, Context: clock.Join(merged)}, nil
+}
+
+func sameVersions(a, b []clock.Version) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	dots := make(map[clock.Dot]bool, len(a))
+	for _, v := range a {
+		dots[v.Clock.Dot] = true
+	}
+	for _, v := range b {
+		if !dots[v.Clock.Dot] {
+			return false
+		}
+	}
+	return true
+}
+
+// Put writes a value with the context of the get before it, nil for a
+// key that wasn't read. The versions in the context are overwritten.
+func (c *Cluster) Put(key, value string, ctx *clock.Vector) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ts := c.targets(key)
+	if len(ts) == 0 {
+		return fmt.Errorf("%w: no replica for %s is up", ErrQuorum, key)
+	}
+	vs := ts[0].n.write(key, ctx, value)
+	for _, t := range ts {
+		t.n.store(key, vs, t.hint)
+	}
+	if len(ts) < c.cfg.W {
+		return fmt.Errorf("%w: %d of %d stored the write", ErrQuorum, len(ts), c.cfg.W)
+	}
+	return nil
+}
+
+// Handoff hands the hints kept for nodes that are back over to them, and
+// returns how many keys it handed over.
+func (c *Cluster) Handoff() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := 0
+	for _, n := range c.nodes {
+		if !n.up {
+			continue
+		}
+		for owner, keys := range n.hints {
+			if o := c.nodes[owner]; o == nil || !o.up {
+				continue
+			}
+			for key, vs := range keys {
+				c.nodes[owner].store(key, vs, "")
+				count++
+			}
+			delete(n.hints, owner)
+		}
+	}
+	return count
+}
+
+// Stored returns the values a node has for a key, hints left out. It's
+// for looking at replicas, it doesn't care if the node is down.
+func (c *Cluster) Stored(id, key string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("dynamo: no node %s", id)
+	}
+	var vals []string
+	for _, v := range n.data[key] {
+		vals = append(vals, v.Value)
+	}
+	sort.Strings(vals)
+	return vals, nil
+}
+
+// Hints returns how many keys a node keeps for other nodes.
+func (c *Cluster) Hints(id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := 0
+	if n, ok := c.nodes[id]; ok {
+		for _, keys := range n.hints {
+			count += len(keys)
+		}
+	}
+	return count
+}
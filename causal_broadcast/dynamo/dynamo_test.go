@@ -0,0 +1,237 @@
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+var ids = []string{"n0", "n1", "n2", "n3", "n4"}
+
+func cluster(t *testing.T, cfg Config) *Cluster {
+	t.Helper()
+	c, err := New(cfg, ids...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRing(t *testing.T) {
+	r := NewRing(16, ids...)
+	primaries := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("key", i)
+		pl := r.PreferenceList(key, 3)
+		if len(pl) != 3 || pl[0] == pl[1] || pl[1] == pl[2] || pl[0] == pl[2] {
+			t.Fatalf("expected 3 different nodes for %s, got %v", key, pl)
+		}
+		if !reflect.DeepEqual(pl, r.PreferenceList(key, 3)) {
+			t.Fatalf("preference list of %s changed", key)
+		}
+		primaries[pl[0]]++
+	}
+	for _, id := range ids {
+		if primaries[id] < 100 {
+			t.Errorf("expected keys to spread out, %s is first for %d of 1000", id, primaries[id])
+		}
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	c := cluster(t, Config{N: 3, R: 2, W: 2, VNodes: 8})
+	if err := c.Put("k", "v", nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Get("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Values, []string{"v"}) {
+		t.Errorf("expected v, got %v", r.Values)
+	}
+	for _, id := range c.PreferenceList("k") {
+		if vals, _ := c.Stored(id, "k"); !reflect.DeepEqual(vals, []string{"v"}) {
+			t.Errorf("expected %s to store v, got %v", id, vals)
+		}
+	}
+	if err := c.Put("k", "w", r.Context); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := c.Get("k"); !reflect.DeepEqual(r.Values, []string{"w"}) {
+		t.Errorf("expected w to overwrite v, got %v", r.Values)
+	}
+}
+
+func TestSiblingsMerged(t *testing.T) {
+	c := cluster(t, Config{N: 3, R: 2, W: 2, VNodes: 8})
+	alice, bob := NewClient(c, nil), NewClient(c, nil)
+
+	// both read the empty cart and add to it.
+	alice.Get("cart")
+	bob.Get("cart")
+	alice.Put("cart", "apples")
+	bob.Put("cart", "pears")
+
+	r, _ := c.Get("cart")
+	if !reflect.DeepEqual(r.Values, []string{"apples", "pears"}) {
+		t.Fatalf("expected both carts as siblings, got %v", r.Values)
+	}
+
+	if err := alice.Update("cart", func(v string, ok bool) string { return v + ",milk" }); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := c.Get("cart"); !reflect.DeepEqual(r.Values, []string{"apples,pears,milk"}) {
+		t.Errorf("expected the merged cart, got %v", r.Values)
+	}
+}
+
+func TestHintedHandoff(t *testing.T) {
+	c := cluster(t, Config{N: 3, R: 2, W: 3, VNodes: 8})
+	pl := c.PreferenceList("k")
+	down := pl[1]
+	c.Fail(down)
+
+	if err := c.Put("k", "v", nil); err != nil {
+		t.Fatalf("expected a stand-in to take the write, got %v", err)
+	}
+	if vals, _ := c.Stored(down, "k"); vals != nil {
+		t.Fatalf("expected %s to miss the write, has %v", down, vals)
+	}
+	hints := 0
+	for _, id := range ids {
+		hints += c.Hints(id)
+	}
+	if hints != 1 {
+		t.Fatalf("expected one hint, got %d", hints)
+	}
+
+	// nothing is handed over while the node is down.
+	if n := c.Handoff(); n != 0 {
+		t.Errorf("expected no handoff to a node that's down, got %d", n)
+	}
+	c.Recover(down)
+	if n := c.Handoff(); n != 1 {
+		t.Errorf("expected one key handed over, got %d", n)
+	}
+	if vals, _ := c.Stored(down, "k"); !reflect.DeepEqual(vals, []string{"v"}) {
+		t.Errorf("expected %s to have v after the handoff, got %v", down, vals)
+	}
+	for _, id := range ids {
+		if c.Hints(id) != 0 {
+			t.Errorf("expected %s to drop the hint", id)
+		}
+	}
+}
+
+func TestReadRepair(t *testing.T) {
+	c := cluster(t, Config{N: 3, R: 2, W: 2, VNodes: 8})
+	c.Put("k", "old", nil)
+	down := c.PreferenceList("k")[0]
+	c.Fail(down)
+	r, _ := c.Get("k")
+	c.Put("k", "new", r.Context)
+	c.Recover(down)
+
+	// no handoff, the read notices down is behind.
+	if vals, _ := c.Stored(down, "k"); !reflect.DeepEqual(vals, []string{"old"}) {
+		t.Fatalf("expected %s to be behind, has %v", down, vals)
+	}
+	if r, _ := c.Get("k"); !reflect.DeepEqual(r.Values, []string{"new"}) {
+		t.Errorf("expected new, got %v", r.Values)
+	}
+	if vals, _ := c.Stored(down, "k"); !reflect.DeepEqual(vals, []string{"new"}) {
+		t.Errorf("expected the read to repair %s, has %v", down, vals)
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	c, err := New(Config{N: 3, R: 2, W: 3}, "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Fail("a")
+	// no node to stand in for a.
+	if err := c.Put("k", "v", nil); !errors.Is(err, ErrQuorum) {
+		t.Errorf("expected the write quorum to fail, got %v", err)
+	}
+	if _, err := c.Get("k"); err != nil {
+		t.Errorf("expected 2 nodes to be enough to read, got %v", err)
+	}
+	c.Fail("b")
+	if _, err := c.Get("k"); !errors.Is(err, ErrQuorum) {
+		t.Errorf("expected the read quorum to fail, got %v", err)
+	}
+
+	if _, err := New(Config{N: 4, R: 1, W: 1}, "a", "b"); err == nil {
+		t.Error("expected N bigger than the cluster to be refused")
+	}
+	// a node twice on the ring is one node, N would be bigger than that.
+	if _, err := New(Config{N: 2, R: 1, W: 1}, "a", "a"); err == nil {
+		t.Error("expected the same node twice to be refused")
+	}
+}
+
+// TestFailures has clients update keys while nodes fail and come back.
+// Writes that go through are never lost: once every node is back and the
+// hints are handed over, every key has the last value written to it, and
+// every replica on the preference list agrees after a read.
+func TestFailures(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	for seed := int64(0); seed < 30; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		c := cluster(t, Config{N: 3, R: 2, W: 2, VNodes: 8})
+		clients := []*Client{NewClient(c, nil), NewClient(c, nil), NewClient(c, nil)}
+		last := make(map[string]string)
+
+		for step := 0; step < 300; step++ {
+			switch x := rnd.Intn(10); {
+			case x == 0 && len(c.Down()) < 2:
+				c.Fail(ids[rnd.Intn(len(ids))])
+			case x == 1:
+				if down := c.Down(); len(down) > 0 {
+					c.Recover(down[rnd.Intn(len(down))])
+				}
+			case x == 2:
+				c.Handoff()
+			default:
+				cl := clients[rnd.Intn(len(clients))]
+				key := keys[rnd.Intn(len(keys))]
+				item := fmt.Sprint(step)
+				err := cl.Update(key, func(v string, ok bool) string {
+					if !ok {
+						return item
+					}
+					return v + "," + item
+				})
+				if err == nil {
+					last[key] = item
+				} else if !errors.Is(err, ErrQuorum) {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		for _, id := range c.Down() {
+			c.Recover(id)
+		}
+		c.Handoff()
+		for _, key := range keys {
+			r, err := c.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := UnionMerge(r.Values)
+			if last[key] != "" && !reflect.DeepEqual(UnionMerge([]string{v, last[key]}), v) {
+				t.Errorf("seed %d: lost %s from %s, have %v", seed, last[key], key, r.Values)
+			}
+			for _, id := range c.PreferenceList(key) {
+				if vals, _ := c.Stored(id, key); !reflect.DeepEqual(vals, r.Values) {
+					t.Errorf("seed %d: %s has %v for %s after the read, expected %v", seed, id, vals, key, r.Values)
+				}
+			}
+		}
+	}
+}
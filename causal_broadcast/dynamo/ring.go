@@ -0,0 +1,73 @@
+package dynamo
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Ring is a consistent hashing ring. Every node sits on it at a few
+// points, and a key belongs to the nodes that come after it going round.
+// Adding or taking away a node only moves the keys next to its points.
+type Ring struct {
+	points []point
+}
+
+type point struct {
+	hash uint32
+	id   string
+}
+
+// hash is md5 like Dynamo, fnv and friends bunch up on ids that only
+// differ in a digit.
+func hash(s string) uint32 {
+	sum := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// NewRing puts every node on the ring at vnodes points, more points
+// spread the keys more evenly.
+func NewRing(vnodes int, ids ...string) *Ring {
+	if vnodes < 1 {
+		vnodes = 1
+	}
+	r := &Ring{}
+	for _, id := range ids {
+		for i := 0; i < vnodes; i++ {
+			r.points = append(r.points, point{hash(fmt.Sprintf("%s#%d", id, i)), id})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		return r.points[i].id < r.points[j].id
+	})
+	return r
+}
+
+// Walk returns every node in the order they come after the key.
+func (r *Ring) Walk(key string) []string {
+	h := hash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	seen := make(map[string]bool)
+	var ids []string
+	for i := 0; i < len(r.points); i++ {
+		p := r.points[(start+i)%len(r.points)]
+		if !seen[p.id] {
+			seen[p.id] = true
+			ids = append(ids, p.id)
+		}
+	}
+	return ids
+}
+
+// PreferenceList returns the n nodes that hold the key.
+func (r *Ring) PreferenceList(key string, n int) []string {
+	ids := r.Walk(key)
+	if n < len(ids) {
+		ids = ids[:n]
+	}
+	return ids
+}
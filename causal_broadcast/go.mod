@@ -2,4 +2,9 @@ module github.com/Joe-Degs/distributed_systems/causal_broadcast
 
 go 1.16
 
-require github.com/davecgh/go-spew v1.1.1
+require (
+	github.com/Joe-Degs/distributed_systems/logical_clocks v0.0.0
+	github.com/davecgh/go-spew v1.1.1
+)
+
+replace github.com/Joe-Degs/distributed_systems/logical_clocks => ../logical_clocks
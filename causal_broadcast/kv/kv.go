@@ -0,0 +1,184 @@
+// Package kv is a replicated key-value store on top of causal broadcast.
+//
+// Every replica is a node. A Put is a broadcast, and every replica applies
+// the writes in the order its node delivers them, so a write never shows
+// up anywhere before the writes it could have seen. Reads never leave the
+// replica.
+//
+// Causal delivery still lets two replicas write the same key without
+// either seeing the other's write. The clocks of the writes are concurrent
+// then, and a Policy decides what the key holds.
+package kv
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+// Version is a value written to a key.
+type Version struct {
+	Value  string
+	Writer string        // replica that wrote it
+	Clock  *clock.Vector // clock of the write
+}
+
+// Policy resolves the concurrent versions of a key, the versions no other
+// write has overwritten. It gets at least two and returns what the key
+// holds. Replicas apply writes in different orders, so a policy has to
+// come to the same answer whatever order the versions are in.
+type Policy func(concurrent []Version) []Version
+
+// LastWriterWins keeps one of the versions. There's no real time to go on,
+// so the last writer is the one whose clock has seen the most writes, and
+// the highest replica id when that's a tie.
+func LastWriterWins(concurrent []Version) []Version {
+	win := concurrent[0]
+	for _, v := range concurrent[1:] {
+		if later(v, win) {
+			win = v
+		}
+	}
+	return []Version{win}
+}
+
+func later(a, b Version) bool {
+	sa, sb := sum(a.Clock), sum(b.Clock)
+	if sa != sb {
+		return sa > sb
+	}
+	return a.Writer > b.Writer
+}
+
+func sum(c *clock.Vector) int {
+	n := 0
+	for _, val := range c.Values() {
+		n += val
+	}
+	return n
+}
+
+// KeepSiblings keeps every version and leaves it to whoever reads the key,
+// the next write to it overwrites all of them.
+func KeepSiblings(concurrent []Version) []Version {
+	return concurrent
+}
+
+// write is what goes in the message of a Put.
+type write struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Store is a replica.
+type Store struct {
+	node   *node.Node
+	policy Policy
+
+	mu   sync.Mutex
+	data map[string][]Version
+}
+
+// New makes a replica of the node, LastWriterWins when policy is nil. The
+// store takes over the node's OnDeliver.
+func New(n *node.Node, policy Policy) *Store {
+	if policy == nil {
+		policy = LastWriterWins
+	}
+	s := &Store{node: n, policy: policy, data: make(map[string][]Version)}
+	n.OnDeliver = s.apply
+	return s
+}
+
+// Put writes a value and returns the message to hand to every other
+// replica.
+func (s *Store) Put(key, value string) ([]byte, error) {
+	msg, err := json.Marshal(write{key, value})
+	if err != nil {
+		return nil, err
+	}
+	return s.node.GenEvent(string(msg))
+}
+
+// Write hands the message of a Put on another replica to this one. It's
+// applied once the node delivers it.
+func (s *Store) Write(p []byte) (int, error) {
+	return s.node.Write(p)
+}
+
+// apply applies a delivered write.
+func (s *Store) apply(e *node.Event) {
+	var w write
+	if err := json.Unmarshal([]byte(e.Msg), &w); err != nil {
+		return // not a write
+	}
+	c, err := e.Clock()
+	if err != nil {
+		return // the node only delivers events with a clock
+	}
+	v := Version{Value: w.Value, Writer: e.Id, Clock: c}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// causal delivery means nothing applied already happened after this
+	// write, whatever is there happened before it or concurrently.
+	var concurrent []Version
+	for _, old := range s.data[w.Key] {
+		switch old.Clock.Compare(v.Clock) {
+		case clock.Equal:
+			return // seen it
+		case clock.Concurrent:
+			concurrent = append(concurrent, old)
+		}
+	}
+	if len(concurrent) == 0 {
+		s.data[w.Key] = []Version{v}
+		return
+	}
+	s.data[w.Key] = s.policy(append(concurrent, v))
+}
+
+// Get returns the versions of a key, more than one only when the policy
+// keeps siblings. They're sorted by writer.
+func (s *Store) Get(key string) []Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vs := make([]Version, len(s.data[key]))
+	for i, v := range s.data[key] {
+		vs[i] = Version{Value: v.Value, Writer: v.Writer, Clock: v.Clock.Copy()}
+	}
+	sort.Slice(vs, func(i, j int) bool {
+		if vs[i].Writer != vs[j].Writer {
+			return vs[i].Writer < vs[j].Writer
+		}
+		return vs[i].Clock.String() < vs[j].Clock.String()
+	})
+	return vs
+}
+
+// Values returns the values of a key, in the order Get returns them.
+func (s *Store) Values(key string) []string {
+	var vals []string
+	for _, v := range s.Get(key) {
+		vals = append(vals, v.Value)
+	}
+	return vals
+}
+
+// Keys returns the keys in the store, sorted.
+func (s *Store) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,140 @@
+package kv
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+// cluster makes replicas that know about each other.
+func cluster(policy Policy, ids ...string) []*Store {
+	var stores []*Store
+	for _, id := range ids {
+		n := node.New(id)
+		for _, other := range ids {
+			if other != id {
+				n.Clock.AddMember(other, 0)
+			}
+		}
+		stores = append(stores, New(n, policy))
+	}
+	return stores
+}
+
+func TestCausalOverwrite(t *testing.T) {
+	stores := cluster(KeepSiblings, "a", "b")
+	a, b := stores[0], stores[1]
+
+	p, _ := a.Put("x", "1")
+	b.Write(p)
+	p, _ = b.Put("x", "2")
+	a.Write(p)
+
+	for _, s := range stores {
+		if vals := s.Values("x"); !reflect.DeepEqual(vals, []string{"2"}) {
+			t.Errorf("expected the later write to overwrite, got %v", vals)
+		}
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		want   []string
+	}{
+		{"last writer wins", LastWriterWins, []string{"from b"}},
+		{"siblings", KeepSiblings, []string{"from a", "from b"}},
+	} {
+		stores := cluster(tc.policy, "a", "b")
+		a, b := stores[0], stores[1]
+
+		pa, _ := a.Put("x", "from a")
+		pb, _ := b.Put("x", "from b")
+		a.Write(pb)
+		b.Write(pa)
+
+		for _, s := range stores {
+			if vals := s.Values("x"); !reflect.DeepEqual(vals, tc.want) {
+				t.Errorf("%s: expected %v, got %v", tc.name, tc.want, vals)
+			}
+		}
+	}
+}
+
+func TestSiblingsOverwritten(t *testing.T) {
+	stores := cluster(KeepSiblings, "a", "b")
+	a, b := stores[0], stores[1]
+
+	pa, _ := a.Put("x", "from a")
+	pb, _ := b.Put("x", "from b")
+	a.Write(pb)
+	b.Write(pa)
+	if len(a.Get("x")) != 2 {
+		t.Fatalf("expected siblings, got %v", a.Values("x"))
+	}
+
+	// a has seen both, its write overwrites both.
+	p, _ := a.Put("x", "merged")
+	b.Write(p)
+	for _, s := range stores {
+		if vals := s.Values("x"); !reflect.DeepEqual(vals, []string{"merged"}) {
+			t.Errorf("expected the merge to overwrite the siblings, got %v", vals)
+		}
+	}
+}
+
+// TestConvergence has replicas put to a few keys while the network hands
+// the puts over in random orders, and checks every replica ends up the
+// same once everything is delivered.
+func TestConvergence(t *testing.T) {
+	type flight struct {
+		to int
+		p  []byte
+	}
+	keys := []string{"x", "y", "z"}
+
+	for _, policy := range []struct {
+		name string
+		p    Policy
+	}{{"last writer wins", LastWriterWins}, {"siblings", KeepSiblings}} {
+		for seed := int64(0); seed < 50; seed++ {
+			rnd := rand.New(rand.NewSource(seed))
+			stores := cluster(policy.p, "a", "b", "c", "d")
+			var inFlight []flight
+
+			for puts := 0; puts < 30 || len(inFlight) > 0; {
+				if puts < 30 && (len(inFlight) == 0 || rnd.Intn(2) == 0) {
+					from := rnd.Intn(len(stores))
+					p, err := stores[from].Put(keys[rnd.Intn(len(keys))], fmt.Sprintf("v%d", puts))
+					if err != nil {
+						t.Fatal(err)
+					}
+					for i := range stores {
+						if i != from {
+							inFlight = append(inFlight, flight{i, p})
+						}
+					}
+					puts++
+					continue
+				}
+				i := rnd.Intn(len(inFlight))
+				f := inFlight[i]
+				inFlight = append(inFlight[:i], inFlight[i+1:]...)
+				stores[f.to].Write(f.p)
+			}
+
+			for _, k := range keys {
+				want := stores[0].Values(k)
+				for _, s := range stores[1:] {
+					if got := s.Values(k); !reflect.DeepEqual(got, want) {
+						t.Errorf("%s, seed %d: %s is %v on one replica and %v on another", policy.name, seed, k, want, got)
+					}
+				}
+			}
+		}
+	}
+}
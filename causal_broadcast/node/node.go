@@ -6,10 +6,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
-	"strconv"
-	"strings"
 	"sync"
-	"time"
 
 	"github.com/Joe-Degs/distributed_systems/causal_broadcast/clock"
 )
@@ -29,6 +26,11 @@ type Node struct {
 	// events nodes has recieved but has not delivered due to causal
 	// anomalies.
 	Queue *EventQueue
+
+	// OnDeliver is called with every event the node delivers, in the
+	// order it delivers them. The node delivers its own events as it
+	// makes them, they don't go into History.
+	OnDeliver func(*Event)
 }
 
 /*
@@ -42,23 +44,25 @@ type Node struct {
 
 // Event is log of a single recieved event.
 type Event struct {
-	Id        string `json:"id,omitempty"`
-	Timestamp string `json:"timestamp,omitempty"`
-	Msg       string `json:"msg,omitempty"`
+	Id        string        `json:"id,omitempty"`
+	Timestamp *clock.Vector `json:"timestamp,omitempty"`
+	Msg       string        `json:"msg,omitempty"`
 }
 
+var errNoTimestamp = errors.New("event has no timestamp")
+
 // Clock returns the clock of the eventlog at the time of event generation.
-func (e *Event) Clock() *clock.Vector {
+// The timestamp is a json object on the wire and doesn't know whose clock
+// it is, the clock comes back with the id of the sender.
+func (e *Event) Clock() (*clock.Vector, error) {
+	if e.Timestamp == nil {
+		return nil, errNoTimestamp
+	}
 	c := clock.New(e.Id)
-	for _, item := range strings.Split(e.Timestamp[1:len(e.Timestamp)-1], " ") {
-		split := strings.Split(item, ":")
-		val, err := strconv.Atoi(split[1])
-		if err != nil {
-			panic(err)
-		}
-		c.AddMember(split[0], val)
+	for id, val := range e.Timestamp.Values() {
+		c.AddMember(id, val)
 	}
-	return c
+	return c, nil
 }
 
 // Marshal returns the json of an event.
@@ -78,12 +82,17 @@ func Unmarshal(b []byte) (*Event, error) {
 
 // EventQueue contains recent events that can't be
 // delivered to node due to causal anomalies.
+//
+// It is a ring, idx is where the next item goes in and next is where the
+// next item comes out. stop is set when the ring is full. The ring used to
+// work out if it was full or empty from idx and next alone, and got it
+// wrong when idx wrapped around, keeping a count is a lot less clever.
 type EventQueue struct {
-	next, idx int
-	stop      bool
-	q         []*Event
-	buf       *Event
-	mu        *sync.Mutex
+	next, idx, n int
+	stop         bool
+	q            []*Event
+	buf          *Event
+	mu           *sync.Mutex
 }
 
 func NewQueue(length int) *EventQueue {
@@ -93,59 +102,26 @@ func NewQueue(length int) *EventQueue {
 	}
 }
 
-// Append puts an item at the next vacant slot of the queue.
+// Append puts an item at the next vacant slot of the queue, false if
+// the queue is full.
 func (eq *EventQueue) Append(e *Event) bool {
 	eq.mu.Lock()
 	defer eq.mu.Unlock()
 
-	if eq.stop {
-		// if queue is closed but idx and next are not
-		// pointing to the same element, open queue
-		if eq.next > 0 && eq.idx != eq.next {
-			eq.stop = false
-		} else {
-			return false
-		}
-	}
-
-	// queue is open
-	if eq.idx > 0 {
-		if eq.idx == eq.next {
-			// if where we are reading the next item is same
-			// as where we put the next item, close queue and return.
-			eq.stop = true
-			return false
-		} else if eq.idx == len(eq.q)-1 {
-			// when appending to the end of the queue,
-			// append and check if there's vacancy at the
-			// start of the queue.
-			eq.q[eq.idx] = e
-			if eq.next > 0 {
-				// continue appending if there's vacancy.
-				eq.reset()
-			} else {
-				// stop if there's no vacancy at beginning of queue.
-				eq.stop = true
-			}
-			return true
-		}
+	if eq.n == len(eq.q) {
+		return false
 	}
-
 	eq.q[eq.idx] = e
 	eq.idx = (eq.idx + 1) % len(eq.q)
-
-	// if after appending eq.idx becomes equal to eq.next
-	// stop halt the next append.
-	if eq.idx > 0 && eq.idx == eq.next {
-		eq.stop = true
-	}
-
+	eq.n++
+	eq.stop = eq.n == len(eq.q)
 	return true
 }
 
+// reset puts idx and next back at the start of the ring once it's empty.
 func (eq *EventQueue) reset() {
 	eq.stop = false
-	eq.idx = (eq.idx + 1) % len(eq.q)
+	eq.idx, eq.next = 0, 0
 
 	// funny story:
 	//	i was trying to lock this function and defer the unlock
@@ -155,33 +131,44 @@ func (eq *EventQueue) reset() {
 	//	pretty sure its a deadlock.
 }
 
-// Next returns the next item in the queue.
+// Next returns the next item in the queue, false if the queue is empty.
 func (eq *EventQueue) Next() (*Event, bool) {
 	eq.mu.Lock()
 	defer eq.mu.Unlock()
-	move := func() {
-		eq.buf = eq.q[eq.next]
-		eq.next = (eq.next + 1) % len(eq.q)
-	}
 
-	if eq.stop {
-		if eq.idx == eq.next {
-			move()
-			eq.stop = false
-			return eq.buf, true
-		} else if eq.idx == len(eq.q)-1 {
-			// queue insertion at the end, vacancy at the start
-			eq.reset()
-		} else if eq.next != eq.idx {
-			// if idx and next are not the same open queue for appending.
-			eq.reset()
-		}
+	if eq.n == 0 {
+		return nil, false
+	}
+	eq.buf = eq.q[eq.next]
+	eq.q[eq.next] = nil
+	eq.next = (eq.next + 1) % len(eq.q)
+	eq.n--
+	eq.stop = false
+	if eq.n == 0 {
+		eq.reset()
 	}
-
-	move()
 	return eq.buf, true
 }
 
+// Len returns the number of items in the queue.
+func (eq *EventQueue) Len() int {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	return eq.n
+}
+
+// grow doubles the size of the queue, keeping the items in order.
+func (eq *EventQueue) grow() {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+
+	q := make([]*Event, 2*len(eq.q)+1)
+	for i := 0; i < eq.n; i++ {
+		q[i] = eq.q[(eq.next+i)%len(eq.q)]
+	}
+	eq.q, eq.next, eq.idx, eq.stop = q, 0, eq.n, false
+}
+
 func (eq *EventQueue) Retry() *Event {
 	return eq.buf
 }
@@ -212,7 +199,7 @@ func (n *Node) ProcessEvent(event *Event) error {
 	var eventJson string
 	var err error
 	if event == nil {
-		eventJson := n.buf.String()
+		eventJson = n.buf.String()
 		if eventJson == "" || eventJson == "<nil>" {
 			return errors.New("message is probably empty")
 		}
@@ -222,41 +209,72 @@ func (n *Node) ProcessEvent(event *Event) error {
 		}
 	}
 
-	eventClock := event.Clock()
-
 	// an event has arrived. we have to know whether its safe to deliver
 	// or we abort delivery and stick in a queue and try again sometime.
-	if eventClock.IsCausallyConsistentWith(n.Clock) {
-		// alls good deliver the message.
-		n.Clock.Merge(eventClock)
-		n.History = append(n.History, eventJson)
+	delivered, err := n.deliver(event, eventJson)
+	if err != nil {
+		return err
+	}
+	if delivered {
+		// whatever was waiting on this one can go now.
+		n.Drain()
 		return nil
 	}
 
-	stat := n.Queue.Append(event)
-	if !stat {
-		// if append fails try again until you can insert.
-		// this feel extremely inefficient, i have to find
-		// a better way to do this!.
-		// TODO(joe)
-		go func() {
-			tts := 100
-		LOOP:
-			for {
-				select {
-				case <-time.After(time.Millisecond * time.Duration(tts)):
-					stat = n.Queue.Append(event)
-					if stat {
-						break LOOP
-					}
-					tts = (tts % 1000) + 100
-				}
-			}
-		}()
+	// the queue used to be retried from a goroutine when it was full,
+	// sleeping longer every time. it just grows now.
+	for !n.Queue.Append(event) {
+		n.Queue.grow()
 	}
 	return ErrEventQueued
 }
 
+// deliver delivers the event if its causally safe to.
+func (n *Node) deliver(event *Event, eventJson string) (bool, error) {
+	eventClock, err := event.Clock()
+	if err != nil {
+		return false, err
+	}
+	if !eventClock.IsCausallyConsistentWith(n.Clock) {
+		return false, nil
+	}
+	// alls good deliver the message.
+	if eventJson == "" {
+		p, err := event.Marshal()
+		if err != nil {
+			return false, err
+		}
+		eventJson = string(p)
+	}
+	n.Clock.Merge(eventClock)
+	n.History = append(n.History, eventJson)
+	if n.OnDeliver != nil {
+		n.OnDeliver(event)
+	}
+	return true, nil
+}
+
+// Drain delivers the queued events that became safe to deliver, until
+// none of the ones left are. It returns how many it delivered.
+func (n *Node) Drain() int {
+	count := 0
+	for {
+		progress := false
+		for i, l := 0, n.Queue.Len(); i < l; i++ {
+			e, _ := n.Queue.Next()
+			if ok, _ := n.deliver(e, ""); ok {
+				count++
+				progress = true
+				continue
+			}
+			n.Queue.Append(e)
+		}
+		if !progress {
+			return count
+		}
+	}
+}
+
 // GenEvent generates
 func (n *Node) GenEvent(msg string) ([]byte, error) {
 	// this is an event that will be sent out to other
@@ -264,7 +282,7 @@ func (n *Node) GenEvent(msg string) ([]byte, error) {
 	n.Clock.Increment()
 	event := &Event{
 		Id:        n.Id,
-		Timestamp: n.Clock.String(),
+		Timestamp: n.Clock.Copy(),
 		Msg:       msg,
 	}
 
@@ -273,6 +291,10 @@ func (n *Node) GenEvent(msg string) ([]byte, error) {
 		n.Clock.Decrement()
 		return nil, err
 	}
+
+	if n.OnDeliver != nil {
+		n.OnDeliver(event)
+	}
 	return p, nil
 }
 
@@ -284,16 +306,11 @@ func (n *Node) Write(p []byte) (l int, err error) {
 	if l, err = n.buf.Write(p); err != nil || l != len(p) {
 		return
 	}
-	if err := n.ProcessEvent(nil); err != nil {
-		if errors.Is(err, ErrEventQueued) {
-			// TODO(joe)
-			// start the queue draining process
-			return 0, nil
-		} else {
-			return l, err
-		}
+	// the buffer has to be emptied when the event is queued too, or
+	// the next write ends up behind it.
+	defer n.buf.Reset()
+	if err := n.ProcessEvent(nil); err != nil && !errors.Is(err, ErrEventQueued) {
+		return l, err
 	}
-
-	n.buf.Reset()
 	return
 }
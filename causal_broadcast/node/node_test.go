@@ -18,19 +18,33 @@ func TestNewNode(t *testing.T) {
 func TestTimestampConversions(t *testing.T) {
 	n := New("jude")
 	n.Clock.AddMember("joe", 0)
+	n.Clock.Increment()
 	e := &Event{
-		Id:        "joe",
-		Timestamp: n.Clock.String(),
+		Id:        "jude",
+		Timestamp: n.Clock.Copy(),
 		Msg:       "message",
 	}
 
 	// print timestamp
 	t.Log(e.Timestamp)
-	cl := e.Clock()
-	if e.Timestamp != cl.String() {
-		t.Errorf("expected %s, got %s", e.Timestamp, cl.String())
+	cl, err := e.Clock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Timestamp.String() != cl.String() || cl.GetId() != "jude" || cl.Get() != 1 {
+		t.Errorf("expected %s of jude, got %s of %s", e.Timestamp, cl, cl.GetId())
+	}
+
+	if _, err := (&Event{Id: "joe"}).Clock(); err == nil {
+		t.Error("expected an error for an event without a timestamp")
+	}
+	// the clock used to go over the wire as its String.
+	if _, err := Unmarshal([]byte(`{"id":"joe","timestamp":"[joe:1]"}`)); err == nil {
+		t.Error("expected an error for a timestamp that isn't a json object")
+	}
+	if err := n.ProcessEvent(&Event{Id: "joe", Msg: "no clock"}); err == nil {
+		t.Error("expected an event without a timestamp to be turned away")
 	}
-	t.Log(cl.String())
 }
 
 func TestEventJson(t *testing.T) {
@@ -39,7 +53,7 @@ func TestEventJson(t *testing.T) {
 	n.Clock.AddMember("messi", 4)
 	event := &Event{
 		Id:        n.Id,
-		Timestamp: n.Clock.String(),
+		Timestamp: n.Clock.Copy(),
 		Msg:       "cryptic message",
 	}
 
@@ -53,19 +67,12 @@ func TestEventJson(t *testing.T) {
 	// unnarshal json back to eventlog
 	uevent, err := Unmarshal(json)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
 	// check if the two structs are equal.
-	elements := []string{"Id", "Timestamp", "Msg"}
-	a := reflect.Indirect(reflect.ValueOf(event))
-	b := reflect.Indirect(reflect.ValueOf(uevent))
-	for _, el := range elements {
-		c := a.FieldByName(el)
-		d := b.FieldByName(el)
-		if c.String() != d.String() {
-			t.Errorf("expected %s and %s to be equal", c.String(), d.String())
-		}
+	if event.Id != uevent.Id || event.Msg != uevent.Msg || event.Timestamp.String() != uevent.Timestamp.String() {
+		t.Errorf("expected %+v and %+v to be equal", event, uevent)
 	}
 }
 
@@ -203,3 +210,76 @@ func TestNodeIO(t *testing.T) {
 	}
 	spew.Dump(anodaNode.History)
 }
+
+func TestQueueWrapsAround(t *testing.T) {
+	q := NewQueue(3)
+	ids := []string{"a", "b", "c", "d", "e", "f", "g"}
+	var got []string
+	in := 0
+	// keep the queue two deep so idx and next both go round the ring.
+	for len(got) < len(ids) {
+		for in < len(ids) && q.Len() < 2 {
+			if !q.Append(&Event{Id: ids[in]}) {
+				t.Fatalf("append of %s failed with %d queued", ids[in], q.Len())
+			}
+			in++
+		}
+		e, ok := q.Next()
+		if !ok {
+			t.Fatalf("queue says its empty with %d queued", q.Len())
+		}
+		got = append(got, e.Id)
+	}
+	if !reflect.DeepEqual(got, ids) {
+		t.Errorf("expected %v out of the queue, got %v", ids, got)
+	}
+	if _, ok := q.Next(); ok {
+		t.Error("Next on an empty queue should say so")
+	}
+}
+
+func TestWriteAfterQueuedEvent(t *testing.T) {
+	a, b := New("a"), New("b")
+	a.Clock.AddMember("b", 0)
+	b.Clock.AddMember("a", 0)
+	first, _ := a.GenEvent("first")
+	second, _ := a.GenEvent("second")
+
+	// second is early and waits, the write after it used to read both
+	// events out of the buffer at once.
+	if _, err := b.Write(second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Write(first); err != nil {
+		t.Fatalf("write after a queued event: %v", err)
+	}
+	if len(b.History) == 0 || b.History[0] != string(first) {
+		t.Errorf("expected first to be delivered, got %v", b.History)
+	}
+}
+
+func TestQueuedEventsDrain(t *testing.T) {
+	a, b := New("a"), New("b")
+	a.Clock.AddMember("b", 0)
+	b.Clock.AddMember("a", 0)
+	var sent [][]byte
+	for i := 0; i < 12; i++ {
+		p, _ := a.GenEvent("event")
+		sent = append(sent, p)
+	}
+
+	// everything but the first shows up too early and waits, the queue
+	// has to grow to hold them all.
+	for i := len(sent) - 1; i > 0; i-- {
+		if _, err := b.Write(sent[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(b.History) != 0 || b.Queue.Len() != 11 {
+		t.Fatalf("expected 11 events queued, got %d queued and %d delivered", b.Queue.Len(), len(b.History))
+	}
+	b.Write(sent[0])
+	if len(b.History) != 12 || b.Queue.Len() != 0 {
+		t.Errorf("expected the queue to drain, got %d queued and %d delivered", b.Queue.Len(), len(b.History))
+	}
+}
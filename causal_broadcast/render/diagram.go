@@ -0,0 +1,71 @@
+// Package render draws the histories of causal broadcast runs with the
+// space-time diagrams and ShiViz logs of the logical_clocks module. It's
+// kept out of node so nodes don't need the renderer to run.
+package render
+
+import (
+	"fmt"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/check"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+	"github.com/Joe-Degs/distributed_systems/logical_clocks/spacetime"
+)
+
+// Diagram turns the histories of the nodes into a space-time diagram. A
+// node's own events are sends, the events of others it delivered are recvs
+// with an arrow back to where they were sent. Clocks only move on sends,
+// so the sender's own entry in the timestamp tells which of its sends an
+// event is.
+func Diagram(hs []check.History) (spacetime.Diagram, error) {
+	var d spacetime.Diagram
+	sends := make(map[sent]spacetime.EventRef)
+	for _, h := range hs {
+		d.Nodes = append(d.Nodes, h.Node)
+		for seq, e := range h.Events {
+			if e.Id != h.Node {
+				continue
+			}
+			s, err := sentBy(e)
+			if err != nil {
+				return d, err
+			}
+			sends[s] = spacetime.EventRef{Node: h.Node, Seq: seq}
+		}
+	}
+
+	for _, h := range hs {
+		for seq, e := range h.Events {
+			s, err := sentBy(e)
+			if err != nil {
+				return d, err
+			}
+			ev := spacetime.Event{Node: h.Node, Seq: seq, Kind: spacetime.Send, Label: e.Timestamp.String()}
+			if e.Id != h.Node {
+				ev.Kind = spacetime.Recv
+				if from, ok := sends[s]; ok {
+					d.Messages = append(d.Messages, spacetime.Message{
+						From:  from,
+						To:    spacetime.EventRef{Node: h.Node, Seq: seq},
+						Label: e.Msg,
+					})
+				}
+			}
+			d.Events = append(d.Events, ev)
+		}
+	}
+	return d, nil
+}
+
+// sent identifies a broadcast by its sender and the sender's count.
+type sent struct {
+	id    string
+	count int
+}
+
+func sentBy(e *node.Event) (sent, error) {
+	c, err := e.Clock()
+	if err != nil {
+		return sent{}, fmt.Errorf("event from %s: %v", e.Id, err)
+	}
+	return sent{e.Id, c.Get()}, nil
+}
@@ -0,0 +1,69 @@
+package render
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/check"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+func TestDiagram(t *testing.T) {
+	a, b, c := node.New("a"), node.New("b"), node.New("c")
+	for _, n := range []*node.Node{a, b, c} {
+		for _, other := range []string{"a", "b", "c"} {
+			if other != n.Id {
+				n.Clock.AddMember(other, 0)
+			}
+		}
+	}
+	rec := check.Record(a, b, c)
+
+	// a broadcasts, b delivers and answers, c gets both in order.
+	p, _ := a.GenEvent("question")
+	b.Write(p)
+	q, _ := b.GenEvent("answer")
+	c.Write(p)
+	c.Write(q)
+	a.Write(q)
+
+	d, err := Diagram(rec.Histories())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Events) != 6 || len(d.Messages) != 4 {
+		t.Errorf("expected 6 events and 4 messages, got %d and %d", len(d.Events), len(d.Messages))
+	}
+	var svg bytes.Buffer
+	if err := d.WriteSVG(&svg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShiViz(t *testing.T) {
+	a, b := node.New("a"), node.New("b")
+	a.Clock.AddMember("b", 0)
+	b.Clock.AddMember("a", 0)
+	rec := check.Record(b, a)
+	p, _ := a.GenEvent("one")
+	q, _ := a.GenEvent("two")
+	b.Write(p)
+	b.Write(q)
+	r, _ := b.GenEvent("three")
+	a.Write(r)
+
+	var buf bytes.Buffer
+	if err := WriteShiViz(&buf, rec.Histories()); err != nil {
+		t.Fatal(err)
+	}
+	want := `b {"a":1,"b":1} deliver one from a
+b {"a":2,"b":2} deliver two from a
+b {"a":2,"b":3} send three
+a {"a":1} send one
+a {"a":2} send two
+a {"a":3,"b":3} deliver three from b
+`
+	if buf.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, buf.String())
+	}
+}
@@ -0,0 +1,91 @@
+package render
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/check"
+	"github.com/Joe-Degs/distributed_systems/logical_clocks/shiviz"
+)
+
+// ShiViz turns the histories of the nodes into events for ShiViz.
+//
+// The clocks of the nodes only move on sends, ShiViz wants every event of a
+// host to move the host's own entry. So the clocks are worked out again
+// from the histories, every send and every delivery is an event, and a
+// delivery merges the clock of the send it delivers.
+func ShiViz(hs []check.History) ([]shiviz.Event, error) {
+	member := make(map[string]bool)
+	for _, h := range hs {
+		member[h.Node] = true
+	}
+
+	out := make([][]shiviz.Event, len(hs))
+	sends := make(map[sent]map[string]int)
+	clocks := make([]map[string]int, len(hs))
+	for i := range clocks {
+		clocks[i] = make(map[string]int)
+	}
+
+	// a delivery has to wait for the clock of its send, so go round the
+	// nodes until everything is done.
+	next := make([]int, len(hs))
+	for done := false; !done; {
+		done = true
+		progress := false
+		for i, h := range hs {
+			for ; next[i] < len(h.Events); next[i]++ {
+				e, clk := h.Events[next[i]], clocks[i]
+				s, err := sentBy(e)
+				if err != nil {
+					return nil, err
+				}
+				text := "send " + e.Msg
+				if e.Id != h.Node {
+					from, ok := sends[s]
+					if !ok && member[e.Id] {
+						break // not sent yet as far as we know
+					}
+					for id, c := range from {
+						if c > clk[id] {
+							clk[id] = c
+						}
+					}
+					text = fmt.Sprintf("deliver %s from %s", e.Msg, e.Id)
+				}
+				clk[h.Node]++
+				snap := make(map[string]int, len(clk))
+				for id, c := range clk {
+					snap[id] = c
+				}
+				if e.Id == h.Node {
+					sends[s] = snap
+				}
+				out[i] = append(out[i], shiviz.Event{Host: h.Node, Clock: snap, Text: text})
+				progress = true
+			}
+			if next[i] < len(h.Events) {
+				done = false
+			}
+		}
+		if !done && !progress {
+			return nil, fmt.Errorf("histories deliver events before they are sent")
+		}
+	}
+
+	var all []shiviz.Event
+	for _, evs := range out {
+		all = append(all, evs...)
+	}
+	return all, nil
+}
+
+// WriteShiViz writes the histories of the nodes in the format of
+// shiviz.Regex.
+func WriteShiViz(w io.Writer, hs []check.History) error {
+	events, err := ShiViz(hs)
+	if err != nil {
+		return err
+	}
+	return shiviz.Write(w, events)
+}
@@ -0,0 +1,129 @@
+package simulate
+
+import (
+	"sort"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/check"
+)
+
+// The model checker doesn't keep states around, a node is a pile of json
+// and mutexes and copying one is asking for trouble. It keeps schedules
+// instead and gets to a state by replaying its schedule on fresh nodes,
+// the same thing Shrink does.
+//
+// Going through every order of every step blows up fast, most of it for
+// nothing. Steps at different nodes don't touch each other, doing them in
+// either order ends up in the same state, so only one of the orders needs
+// looking at. Sleep sets take care of that:
+//
+// -> after exploring a step from a state, the step goes to sleep there.
+// -> the next steps from the state pass the sleeping steps down to the
+//    states after them, but only the ones at other nodes.
+// -> a sleeping step isn't taken again, every run it starts was already
+//    explored with the steps in the other order.
+
+// Exploration is what Explore found.
+type Exploration struct {
+	States int // states looked at
+	Runs   int // complete runs, up to reordering steps at different nodes
+
+	// the first run that breaks causal delivery or liveness, its schedule
+	// exactly as it was explored. nil when there isn't one.
+	Failure *Result
+}
+
+// Explore goes through every order the broadcasts and deliveries of a
+// configuration can happen in. The broadcasts are dealt out to the nodes
+// in turn, so 3 nodes and 4 broadcasts is n0 broadcasting twice and the
+// others once.
+//
+// Causal delivery is checked in every state. Once nothing is left to do
+// every message has to be delivered everywhere and every node has to end
+// up with the same clock, or the run didn't stay live.
+func Explore(cfg Config) Exploration {
+	var ex Exploration
+	ex.explore(cfg, nil, nil)
+	return ex
+}
+
+func (ex *Exploration) explore(cfg Config, schedule []Step, sleep []Step) {
+	if ex.Failure != nil {
+		return
+	}
+	ex.States++
+	s := replay(cfg, schedule)
+
+	enabled := s.enabled()
+	if len(enabled) == 0 {
+		ex.Runs++
+		if r := s.check(0); r.Failed() {
+			ex.Failure = &r
+		}
+		return
+	}
+	if vs := s.safety(); len(vs) > 0 {
+		ex.Failure = &Result{Schedule: s.schedule, Violations: vs}
+		return
+	}
+
+	for _, step := range enabled {
+		if asleep(sleep, step) {
+			continue
+		}
+		var next []Step
+		for _, z := range sleep {
+			if z.Node != step.Node {
+				next = append(next, z)
+			}
+		}
+		ex.explore(cfg, append(append([]Step(nil), schedule...), step), next)
+		if ex.Failure != nil {
+			return
+		}
+		sleep = append(sleep, step)
+	}
+}
+
+func asleep(sleep []Step, step Step) bool {
+	for _, z := range sleep {
+		if z == step {
+			return true
+		}
+	}
+	return false
+}
+
+// enabled returns the steps that can be taken next, the broadcasts nodes
+// have left and then the messages in flight, in the same order every time.
+func (s *sim) enabled() []Step {
+	var steps []Step
+	for i, n := range s.nodes {
+		share := s.cfg.Broadcasts / len(s.nodes)
+		if i < s.cfg.Broadcasts%len(s.nodes) {
+			share++
+		}
+		if s.sent[n.Id] < share {
+			steps = append(steps, Step{Kind: Broadcast, Node: n.Id})
+		}
+	}
+	var deliveries []Step
+	for _, f := range s.inFlight {
+		deliveries = append(deliveries, Step{Kind: Deliver, Node: f.to, Msg: f.msg})
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		a, b := deliveries[i], deliveries[j]
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		if a.Msg.Sender != b.Msg.Sender {
+			return a.Msg.Sender < b.Msg.Sender
+		}
+		return a.Msg.Seq < b.Msg.Seq
+	})
+	return append(steps, deliveries...)
+}
+
+// safety checks what the nodes delivered so far.
+func (s *sim) safety() []check.Violation {
+	return check.Safety(check.Check(s.rec.Histories()))
+}
@@ -0,0 +1,65 @@
+package simulate
+
+import (
+	"testing"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/check"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+func TestExplore(t *testing.T) {
+	ex := Explore(Config{Nodes: 3, Broadcasts: 4})
+	if ex.Failure != nil {
+		t.Fatalf("correct nodes failed: %v", ex.Failure)
+	}
+	t.Logf("%d states, %d runs", ex.States, ex.Runs)
+}
+
+func TestExploreReduces(t *testing.T) {
+	// two nodes broadcasting once each. either node can deliver the other
+	// one's message before or after broadcasting, but not both of them
+	// before, so 3 runs out of the 6 orders of the steps.
+	ex := Explore(Config{Nodes: 2, Broadcasts: 2})
+	if ex.Failure != nil {
+		t.Fatalf("correct nodes failed: %v", ex.Failure)
+	}
+	if ex.Runs != 3 {
+		t.Errorf("expected 3 runs, explored %d", ex.Runs)
+	}
+}
+
+func TestExploreFindsCausalOrder(t *testing.T) {
+	cfg := Config{Nodes: 3, Broadcasts: 4, Write: brokenWrite}
+	ex := Explore(cfg)
+	if ex.Failure == nil {
+		t.Fatal("expected nodes that skip the causal check to get caught")
+	}
+	t.Log(ex.Failure)
+	if ex.Failure.Violations[0].Kind != check.CausalOrder {
+		t.Errorf("expected a causal order violation, got %v", ex.Failure.Violations)
+	}
+	if r := Replay(cfg, ex.Failure.Schedule); !r.Failed() {
+		t.Errorf("replaying the interleaving didn't fail: %v", r)
+	}
+}
+
+// stubbornWrite never delivers anything from n1.
+func stubbornWrite(n *node.Node, p []byte) {
+	if e, err := node.Unmarshal(p); err == nil && e.Id == "n1" {
+		return
+	}
+	n.Write(p)
+}
+
+func TestExploreFindsLiveness(t *testing.T) {
+	ex := Explore(Config{Nodes: 3, Broadcasts: 4, Write: stubbornWrite})
+	if ex.Failure == nil {
+		t.Fatal("expected messages that never get delivered to get caught")
+	}
+	t.Log(ex.Failure)
+	for _, v := range ex.Failure.Violations {
+		if v.Kind != check.Undelivered {
+			t.Errorf("expected only undelivered messages, got %v", v)
+		}
+	}
+}
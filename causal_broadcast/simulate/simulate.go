@@ -0,0 +1,247 @@
+// Package simulate runs causal broadcast nodes on a network that delivers
+// messages in whatever order it likes, and checks the run.
+//
+// A run is a schedule of steps, a node broadcasting or the network handing
+// a message to a node. Random runs come from a seed, the same seed gives
+// the same schedule. A schedule can be replayed on fresh nodes, so a
+// failing one can be shrunk down to the few steps that matter.
+//
+// Random runs miss the rare orders, for small runs Explore goes through
+// every one of them instead.
+package simulate
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/check"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+// kinds of steps.
+const (
+	Broadcast = "broadcast"
+	Deliver   = "deliver"
+)
+
+// Step is one thing that happens in a run.
+type Step struct {
+	Kind string
+	Node string      // node that broadcasts, or gets the message
+	Msg  check.MsgId // message delivered
+}
+
+func (s Step) String() string {
+	if s.Kind == Broadcast {
+		return fmt.Sprintf("%s broadcasts", s.Node)
+	}
+	return fmt.Sprintf("%s gets %s", s.Node, s.Msg)
+}
+
+// Config is the shape of a run.
+type Config struct {
+	Nodes      int
+	Broadcasts int
+
+	// Write hands a message to a node, node.Write when nil. Tests put
+	// broken nodes in here to see the checks catch them.
+	Write func(n *node.Node, p []byte)
+}
+
+// Result is what happened in a run.
+type Result struct {
+	Seed       int64
+	Schedule   []Step
+	Violations []check.Violation
+	Diverged   []string // nodes that ended up with a different clock from the first
+}
+
+// Failed reports whether anything went wrong in the run.
+func (r Result) Failed() bool { return len(r.Violations) > 0 || len(r.Diverged) > 0 }
+
+func (r Result) String() string {
+	return fmt.Sprintf("seed %d, schedule %v: violations %v, diverged %v", r.Seed, r.Schedule, r.Violations, r.Diverged)
+}
+
+// sim is a run in progress.
+type sim struct {
+	cfg      Config
+	nodes    []*node.Node
+	byId     map[string]*node.Node
+	inFlight []flight
+	schedule []Step
+	sent     map[string]int // broadcasts every node made
+	rec      *check.Recorder
+}
+
+// flight is a message on its way to a node.
+type flight struct {
+	to  string
+	msg check.MsgId
+	p   []byte
+}
+
+func newSim(cfg Config) *sim {
+	s := &sim{cfg: cfg, byId: make(map[string]*node.Node), sent: make(map[string]int)}
+	for i := 0; i < cfg.Nodes; i++ {
+		n := node.New(fmt.Sprintf("n%d", i))
+		s.nodes = append(s.nodes, n)
+		s.byId[n.Id] = n
+	}
+	for _, n := range s.nodes {
+		for _, other := range s.nodes {
+			if other != n {
+				n.Clock.AddMember(other.Id, 0)
+			}
+		}
+	}
+	s.rec = check.Record(s.nodes...)
+	return s
+}
+
+func (s *sim) broadcast(id string) {
+	n := s.byId[id]
+	p, err := n.GenEvent(fmt.Sprintf("message %d from %s", n.Clock.Get()+1, id))
+	if err != nil {
+		return
+	}
+	s.schedule = append(s.schedule, Step{Kind: Broadcast, Node: id})
+	s.sent[id]++
+	msg := check.MsgId{Sender: id, Seq: n.Clock.Get()}
+	for _, other := range s.nodes {
+		if other.Id != id {
+			s.inFlight = append(s.inFlight, flight{to: other.Id, msg: msg, p: p})
+		}
+	}
+}
+
+// deliver hands the i'th message in flight to its node.
+func (s *sim) deliver(i int) {
+	f := s.inFlight[i]
+	s.inFlight = append(s.inFlight[:i], s.inFlight[i+1:]...)
+	s.schedule = append(s.schedule, Step{Kind: Deliver, Node: f.to, Msg: f.msg})
+	if s.cfg.Write != nil {
+		s.cfg.Write(s.byId[f.to], f.p)
+		return
+	}
+	s.byId[f.to].Write(f.p)
+}
+
+// find returns where a message to a node is in flight, -1 if it isn't.
+func (s *sim) find(to string, msg check.MsgId) int {
+	for i, f := range s.inFlight {
+		if f.to == to && f.msg == msg {
+			return i
+		}
+	}
+	return -1
+}
+
+// finish delivers whatever is still in flight in the order it was sent,
+// drains the queues and checks the run.
+func (s *sim) finish(seed int64) Result {
+	for len(s.inFlight) > 0 {
+		s.deliver(0)
+	}
+	return s.check(seed)
+}
+
+// check drains the queues and checks the run as it is.
+func (s *sim) check(seed int64) Result {
+	for _, n := range s.nodes {
+		n.Drain()
+	}
+
+	r := Result{Seed: seed, Schedule: s.schedule}
+	r.Violations = check.Check(s.rec.Histories())
+	for _, n := range s.nodes[1:] {
+		if n.Clock.String() != s.nodes[0].Clock.String() {
+			r.Diverged = append(r.Diverged, n.Id)
+		}
+	}
+	return r
+}
+
+// Run makes a random run from the seed. Every step either a node that
+// has broadcasts left broadcasts, or a random message in flight is
+// delivered.
+func Run(cfg Config, seed int64) Result {
+	rnd := rand.New(rand.NewSource(seed))
+	s := newSim(cfg)
+	left := cfg.Broadcasts
+	for left > 0 || len(s.inFlight) > 0 {
+		if left > 0 && (len(s.inFlight) == 0 || rnd.Intn(3) == 0) {
+			s.broadcast(s.nodes[rnd.Intn(len(s.nodes))].Id)
+			left--
+			continue
+		}
+		s.deliver(rnd.Intn(len(s.inFlight)))
+	}
+	return s.finish(seed)
+}
+
+// Replay runs a schedule on fresh nodes. Deliveries of messages that are
+// not in flight are skipped, and whatever the schedule leaves in flight
+// is delivered at the end, so any part of a schedule is a run too.
+func Replay(cfg Config, schedule []Step) Result {
+	return replay(cfg, schedule).finish(0)
+}
+
+// replay runs a schedule on fresh nodes and leaves it there.
+func replay(cfg Config, schedule []Step) *sim {
+	s := newSim(cfg)
+	for _, step := range schedule {
+		switch step.Kind {
+		case Broadcast:
+			if _, ok := s.byId[step.Node]; ok {
+				s.broadcast(step.Node)
+			}
+		case Deliver:
+			if i := s.find(step.Node, step.Msg); i >= 0 {
+				s.deliver(i)
+			}
+		}
+	}
+	return s
+}
+
+// Shrink cuts down a failing schedule while it still fails. It tries
+// throwing away chunks of steps, halving the chunk until single steps,
+// and keeps going until nothing can be thrown away.
+func Shrink(cfg Config, schedule []Step) []Step {
+	if !Replay(cfg, schedule).Failed() {
+		return schedule
+	}
+	for chunk := len(schedule) / 2; chunk >= 1; {
+		shrunk := false
+		for i := 0; i+chunk <= len(schedule); {
+			try := append(append([]Step(nil), schedule[:i]...), schedule[i+chunk:]...)
+			if Replay(cfg, try).Failed() {
+				schedule, shrunk = try, true
+				continue
+			}
+			i++
+		}
+		if !shrunk {
+			chunk /= 2
+		}
+	}
+	// replaying adds the deliveries left in flight, keep the schedule
+	// as it really ran.
+	return Replay(cfg, schedule).Schedule
+}
+
+// Search runs the seeds from 0 up and returns the first run that fails,
+// with its schedule shrunk. false if all of them passed.
+func Search(cfg Config, seeds int64) (Result, bool) {
+	for seed := int64(0); seed < seeds; seed++ {
+		r := Run(cfg, seed)
+		if !r.Failed() {
+			continue
+		}
+		shrunk := Replay(cfg, Shrink(cfg, r.Schedule))
+		shrunk.Seed = seed
+		return shrunk, true
+	}
+	return Result{}, false
+}
@@ -0,0 +1,76 @@
+package simulate
+
+import (
+	"testing"
+
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/check"
+	"github.com/Joe-Degs/distributed_systems/causal_broadcast/node"
+)
+
+func TestRandomRuns(t *testing.T) {
+	for _, cfg := range []Config{
+		{Nodes: 2, Broadcasts: 5},
+		{Nodes: 3, Broadcasts: 10},
+		{Nodes: 5, Broadcasts: 30},
+	} {
+		if r, failed := Search(cfg, 100); failed {
+			t.Errorf("%d nodes, %d broadcasts: %v", cfg.Nodes, cfg.Broadcasts, r)
+		}
+	}
+}
+
+func TestReplayIsDeterministic(t *testing.T) {
+	cfg := Config{Nodes: 3, Broadcasts: 8}
+	a, b := Run(cfg, 7), Run(cfg, 7)
+	if len(a.Schedule) != len(b.Schedule) {
+		t.Fatal("same seed gave different runs")
+	}
+	for i := range a.Schedule {
+		if a.Schedule[i] != b.Schedule[i] {
+			t.Fatalf("same seed gave different runs at step %d", i)
+		}
+	}
+	if r := Replay(cfg, a.Schedule); len(r.Schedule) != len(a.Schedule) || r.Failed() {
+		t.Errorf("replay didn't run the same: %v", r)
+	}
+}
+
+// brokenWrite delivers everything the moment it shows up.
+func brokenWrite(n *node.Node, p []byte) {
+	e, err := node.Unmarshal(p)
+	if err != nil {
+		return
+	}
+	c, err := e.Clock()
+	if err != nil {
+		return
+	}
+	n.Clock.Merge(c)
+	n.History = append(n.History, string(p))
+	n.OnDeliver(e)
+}
+
+func TestShrinkBrokenNode(t *testing.T) {
+	cfg := Config{Nodes: 4, Broadcasts: 12, Write: brokenWrite}
+	r, failed := Search(cfg, 100)
+	if !failed {
+		t.Fatal("expected nodes that skip the causal check to get caught")
+	}
+	t.Logf("seed %d shrunk to %v", r.Seed, r.Schedule)
+	t.Log(r.Violations)
+
+	// one node broadcasts, a second one gets it and broadcasts, a third
+	// gets the second message first. the rest is the leftovers delivered.
+	broadcasts := 0
+	for _, s := range r.Schedule {
+		if s.Kind == Broadcast {
+			broadcasts++
+		}
+	}
+	if broadcasts != 2 {
+		t.Errorf("expected the schedule to shrink to 2 broadcasts, got %d", broadcasts)
+	}
+	if len(r.Violations) == 0 || r.Violations[0].Kind != check.CausalOrder {
+		t.Errorf("expected a causal order violation, got %v", r.Violations)
+	}
+}
@@ -1,6 +1,7 @@
 package clocks
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 )
@@ -119,6 +120,7 @@ type VectorClock struct {
 // in all the others clocks
 //
 // this is going to be harder than i thought it.
+// (it was, Join and Leave on the cluster do it now, see membership.go)
 //
 // First things first. when you join the cluster. you get the last know clock value
 // of every single node in the cluster.
@@ -148,8 +150,25 @@ func (vc *VectorClock) addMember(id string) {
 	vc.val[id] = 0
 }
 
+// String returns the vector as [a:1 b:2], fmt sorts the ids already.
 func (vc *VectorClock) String() string {
-	return strings.Split(fmt.Sprintf("%v", vc.val), "p")[1]
+	return strings.TrimPrefix(fmt.Sprintf("%v", vc.val), "map")
+}
+
+// MarshalJSON writes the vector as a json object of node ids to counts.
+func (vc *VectorClock) MarshalJSON() ([]byte, error) {
+	return json.Marshal(vc.val)
+}
+
+// UnmarshalJSON reads the vector back from a json object, the id of the
+// clock stays what it was.
+func (vc *VectorClock) UnmarshalJSON(p []byte) error {
+	val := make(map[string]int)
+	if err := json.Unmarshal(p, &val); err != nil {
+		return err
+	}
+	vc.val = val
+	return nil
 }
 
 func (vc *VectorClock) Get() interface{} {
@@ -190,18 +209,15 @@ func (vc *VectorClock) Merge(cl Clock) {
 // checks to see if the clocks timestamp happens before its own
 // using the following rule
 // VC(A) < VC(B) if VC(A)i <= VC(B)i for all i and VC(A) != VC(B)
+// nodes come and go, so the clocks don't always have the same entries. a
+// missing entry is a node the clock never heard from, it counts as zero.
 func (vc *VectorClock) HappensBefore(cl Clock) bool {
-	if fmt.Sprint(vc) == fmt.Sprint(cl) {
-		return false
-	}
-	return lessThan(vc.val, cl.Get().(map[string]int))
+	return vectorLess(vc.val, cl.Get())
 }
 
-// compares two vector clocks and returns true if a < b
+// compares two vector clocks and returns true if a <= b, entries missing
+// from a count as zero and can't be bigger than anything in b.
 func lessThan(a, b map[string]int) bool {
-	if len(a) != len(b) {
-		return false
-	}
 	for k, v := range a {
 		if v > b[k] {
 			return false
@@ -210,6 +226,27 @@ func lessThan(a, b map[string]int) bool {
 	return true
 }
 
+// vectorLess compares two recorded vector timestamps and returns true if
+// a < b. Entries missing from one of them count as zero.
+func vectorLess(a, b interface{}) bool {
+	va, vb := a.(map[string]int), b.(map[string]int)
+	less := false
+	for k, v := range va {
+		if v > vb[k] {
+			return false
+		}
+		if v < vb[k] {
+			less = true
+		}
+	}
+	for k, v := range vb {
+		if _, ok := va[k]; !ok && v > 0 {
+			less = true
+		}
+	}
+	return less
+}
+
 // The point of most of the stuff implemented here it to find
 // causal relationships between events happenning on distributed nodes
 // communicating over unreliable networks. And thats one of the big problems
@@ -231,3 +268,60 @@ func (vc *VectorClock) getCausalRelation(other Clock) string {
 	}
 	return "unknown"
 }
+
+// copyTimestamp returns a copy of a timestamp handed out by Get, so
+// it can be stored without changing when the clock moves on.
+func copyTimestamp(ts interface{}) interface{} {
+	m, ok := ts.(map[string]int)
+	if !ok {
+		return ts
+	}
+	c := make(map[string]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// clockOf turns a recorded timestamp back into a clock so it can be
+// compared with the clocks own HappensBefore.
+func clockOf(ts interface{}) Clock {
+	switch t := ts.(type) {
+	case int:
+		return &LamportClock{val: t}
+	case map[string]int:
+		return &VectorClock{val: copyTimestamp(t).(map[string]int)}
+	}
+	for _, ct := range clockTypes {
+		if ct.Restore == nil {
+			continue
+		}
+		if c, ok := ct.Restore(ts); ok {
+			return c
+		}
+	}
+	panic(fmt.Sprintf("clocks: unknown timestamp type %T", ts))
+}
+
+// ClockType is a kind of clock nodes can run. Restore turns a timestamp
+// the clock handed out back into a clock, so recorded timestamps can be
+// compared with its HappensBefore. lamport and vector timestamps are
+// known already and don't need it.
+type ClockType struct {
+	Name    string
+	New     func() Clock
+	Restore func(ts interface{}) (Clock, bool)
+}
+
+var clockTypes = []ClockType{
+	{Name: "lamport", New: NewLamportClock},
+	{Name: "vector", New: NewVectorClock},
+}
+
+// RegisterClock adds a kind of clock to the ones tools compare.
+func RegisterClock(ct ClockType) { clockTypes = append(clockTypes, ct) }
+
+// ClockTypes returns the kinds of clocks there are.
+func ClockTypes() []ClockType {
+	return append([]ClockType(nil), clockTypes...)
+}
@@ -1,13 +1,15 @@
 package clocks
 
 import (
+	"flag"
 	"fmt"
-	"math/rand"
-	"sync"
 	"testing"
 	"time"
 )
 
+// seed of the random runs, pass -seed to try another one.
+var randomSeed = flag.Int64("seed", 42, "seed of the random scheduler runs")
+
 func TestLamportMax(t *testing.T) {
 	l := LamportClock{val: 1}
 	if l.max(2) != 2 {
@@ -85,6 +87,7 @@ func TestLamportClockWithSendAndRecv(t *testing.T) {
 	A.genInternalEvent()
 	A.send(fmt.Sprintf("message from node %s, timestamp at send %d", A.id, A.Get().(int)), B)
 	tsA := A.Get().(int)
+	B.drain() // the message is in B's inbox until B gets to it
 
 	if B.Get().(int) <= tsA {
 		t.Errorf("expected tsA(%d) <= tsB(%d)", tsA, B.Get().(int))
@@ -99,8 +102,10 @@ func TestLamportClockTransitiveClosure(t *testing.T) {
 	}
 	cl.nodes["a"].genInternalEvent()
 	cl.nodes["a"].send("send event from 'a'", cl.nodes["b"])
+	cl.nodes["b"].drain()
 	cl.nodes["b"].genInternalEvent()
 	cl.nodes["b"].send("send event from 'b'", cl.nodes["c"])
+	cl.nodes["c"].drain()
 	cl.appendSortLogs() // consolidates and sorts logs
 
 	//t.Log(cl.dlog)
@@ -127,64 +132,30 @@ func TestLamportClockTransitiveClosure(t *testing.T) {
 // In view of this if A -> B then the LC(A) <= LC(B) but we cannot say
 // for sure that if LC(A) <= LC(B) then A -> B
 func TestRandomBehaviourInClusterWithLamportClock(t *testing.T) {
-	var wg sync.WaitGroup
 	cl := NewCluster(NewLamportClock, "a", "b", "c", "d", "e", "f", "g", "h", "i", "j")
 
-	keys := make([]string, 0, len(cl.nodes))
-	for key, _ := range cl.nodes {
-		keys = append(keys, key)
-	}
-
-	randomInt := func(min, max int) int {
-		rand.Seed(time.Now().UnixNano())
-		return rand.Intn(max-min) + min
-	}
+	// random sends and internal events, replay a failing run with its seed.
+	t.Logf("seed %d", *randomSeed)
+	s := NewScheduler(cl, *randomSeed)
+	s.RandomWorkload(300, time.Second)
+	s.Run()
 
-	randomKey := func() string {
-		return keys[randomInt(0, len(keys))]
+	g, err := cl.HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	// random time.Sleep sends
-	// this will run in a goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for i := 0; i < 20; i++ {
-			k1 := randomKey()
-			var k2 string
-			for {
-				k2 = randomKey()
-				if k1 == k2 {
-					k2 = randomKey()
-				} else {
-					break
-				}
-			}
-			time.Sleep(time.Millisecond * time.Duration(randomInt(10, 50)))
-			cl.nodes[k1].send(fmt.Sprintf("send event from %s to %s",
-				cl.nodes[k1].id, cl.nodes[k2].id), cl.nodes[k2])
-		}
-		return
-	}()
-
-	// random time.Sleep internal events.
-	// this will also run in a goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for i := 0; i < 10; i++ {
-			time.Sleep(time.Millisecond * time.Duration(randomInt(10, 50)))
-			cl.nodes[randomKey()].genInternalEvent()
+	consistent, concurrentOrdered := true, 0
+	for _, m := range g.CheckClocks() {
+		switch m.Causal {
+		case happensBefore, happensAfter:
+			consistent = false
+			t.Errorf("lamport clock order contradicts happens-before: %s", m)
+		case concurrent:
+			concurrentOrdered++
 		}
-		return
-	}()
-	// wait for goroutines to finish
-	wg.Wait()
-
-	cl.appendLogs()
-	for _, log := range cl.dlog {
-		t.Log(log.msg)
-		fmt.Println("")
+	}
+	if consistent {
+		t.Logf("%d pairs of concurrent events got ordered by lamport clocks", concurrentOrdered)
 	}
 }
 
@@ -252,3 +223,59 @@ func TestVectorClockCausalRelationships(t *testing.T) {
 		t.Errorf("event should be concurrent")
 	}
 }
+
+func TestVectorClockSortLogs(t *testing.T) {
+	cl := graphCluster(NewVectorClock)
+	cl.nodes["c"].send("hello from c", cl.nodes["a"])
+	cl.nodes["a"].drain()
+	g, err := cl.HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cl.appendSortLogs() // used to panic on vector timestamps
+	for i := range cl.dlog {
+		for j := i + 1; j < len(cl.dlog); j++ {
+			if g.Before(cl.dlog[j].id(), cl.dlog[i].id()) {
+				t.Errorf("%s happens before %s but was sorted after it",
+					cl.dlog[j].id(), cl.dlog[i].id())
+			}
+		}
+	}
+
+	// sorting again from a different starting order gives the same timeline.
+	again := append([]eventLog(nil), cl.dlog...)
+	again[0], again[len(again)-1] = again[len(again)-1], again[0]
+	sortVectorLog(again)
+	for i := range again {
+		if again[i].id() != cl.dlog[i].id() {
+			t.Fatalf("expected a deterministic order, got %s at %d instead of %s",
+				again[i].id(), i, cl.dlog[i].id())
+		}
+	}
+}
+
+func TestVectorClockLayerLogs(t *testing.T) {
+	cl := graphCluster(NewVectorClock)
+	want := [][]EventId{
+		{{"a", 0}, {"c", 0}},
+		{{"a", 1}},
+		{{"b", 0}},
+		{{"b", 1}},
+	}
+
+	layers := cl.appendLayerLogs()
+	if len(layers) != len(want) {
+		t.Fatalf("expected %d layers, got %d: %v", len(want), len(layers), layers)
+	}
+	for i := range want {
+		if len(layers[i]) != len(want[i]) {
+			t.Fatalf("expected layer %d to be %v, got %v", i, want[i], layers[i])
+		}
+		for j := range want[i] {
+			if layers[i][j].id() != want[i][j] {
+				t.Errorf("expected %s in layer %d, got %s", want[i][j], i, layers[i][j].id())
+			}
+		}
+	}
+}
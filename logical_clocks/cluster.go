@@ -1,17 +1,61 @@
 package clocks
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
 
 // Cluster represents groups of nodes communicating
 type Cluster struct {
 	nodes map[string]*Node
 	dlog  []eventLog
+
+	// nodes are made with clock when they join, nodes that left are kept
+	// in gone for their logs.
+	clock func() Clock
+	gone  map[string]*Node
+
+	// links[from][to] is the FIFO channel messages from one node to
+	// another travel on.
+	links map[string]map[string]*link
+	snap  *snapshotState // snapshot in progress, if any
+
+	// every operation on the cluster is a logical step, faults are
+	// scheduled to happen at a given step.
+	step       int
+	faults     []Fault
+	partition  map[string]int // partition group of every node, 0 is the default
+	groups     int
+	linkFaults map[string]map[string]*linkFault
+	flog       []eventLog // faults that happened, merged into dlog
+
+	// when a scheduler drives the cluster, messages are delivered by it
+	// after a latency picked from the network model.
+	sched *Scheduler
+	net   Network
+
+	// algorithms running on the cluster hear about every message
+	// delivered to a node that is up.
+	handlers []func(m message)
+
+	// and about nodes joining and leaving, after the fact.
+	watchers []func(id string, joined bool)
 }
 
 func NewCluster(clock func() Clock, ids ...string) *Cluster {
-	cl := &Cluster{nodes: make(map[string]*Node)}
+	cl := &Cluster{
+		nodes:      make(map[string]*Node),
+		clock:      clock,
+		gone:       make(map[string]*Node),
+		links:      make(map[string]map[string]*link),
+		partition:  make(map[string]int),
+		linkFaults: make(map[string]map[string]*linkFault),
+		net:        DefaultNetwork,
+	}
 	for _, id := range ids {
 		cl.nodes[id] = NewNode(id, clock())
+		cl.nodes[id].onDeliver = cl.handle
 	}
 	if _, ok := clock().(*VectorClock); ok {
 		for _, node := range cl.nodes {
@@ -46,25 +90,240 @@ func (cl *Cluster) Get(id string) *Node {
 	return nil
 }
 
+// ids returns the ids of the nodes in the cluster in sorted order.
+func (cl *Cluster) ids() []string {
+	ids := make([]string, 0, len(cl.nodes))
+	for id := range cl.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 // consolidates all logs in the cluster and sort them based on the
 // happens before lamport relationship
 func (cl *Cluster) appendLogs() {
-	for _, value := range cl.nodes {
-		cl.dlog = append(cl.dlog, value.log...)
+	cl.dlog = cl.dlog[:0]
+	for _, id := range cl.everyone() {
+		cl.dlog = append(cl.dlog, cl.logOf(id)...)
 	}
+	cl.dlog = append(cl.dlog, cl.flog...)
 	//sortLamportLog(cl.dlog)
 }
 
+// consolidates all logs and sorts them by whatever clock the cluster runs.
 func (cl *Cluster) appendSortLogs() {
 	cl.appendLogs()
+	if len(cl.dlog) == 0 {
+		return
+	}
+	if _, ok := cl.dlog[0].timestamp.(map[string]int); ok {
+		sortVectorLog(cl.dlog)
+		return
+	}
 	sortLamportLog(cl.dlog)
 }
 
+// consolidates all logs of a vector clock cluster into layers of
+// concurrent events.
+func (cl *Cluster) appendLayerLogs() [][]eventLog {
+	cl.appendLogs()
+	return layerVectorLog(cl.dlog)
+}
+
+var (
+	errNoMessage      = errors.New("no message in flight on link")
+	errMessageDelayed = errors.New("message on link is delayed")
+)
+
+// link is a FIFO channel between two nodes. messages sent on a link are
+// delivered in the order they were sent, which is what real networks
+// over tcp give you and what the snapshot algorithm needs.
+type link struct {
+	q []message
+}
+
+func (l *link) push(m message) { l.q = append(l.q, m) }
+
+func (l *link) peek() (message, bool) {
+	if len(l.q) == 0 {
+		return message{}, false
+	}
+	return l.q[0], true
+}
+
+func (l *link) pop() (message, bool) {
+	if len(l.q) == 0 {
+		return message{}, false
+	}
+	m := l.q[0]
+	l.q = l.q[1:]
+	return m, true
+}
+
+// link returns the channel from one node to another, creating it the first
+// time its used.
+func (cl *Cluster) link(from, to string) *link {
+	if cl.links[from] == nil {
+		cl.links[from] = make(map[string]*link)
+	}
+	l, ok := cl.links[from][to]
+	if !ok {
+		l = &link{}
+		cl.links[from][to] = l
+	}
+	return l
+}
+
+// Send records a send event on the sender and puts the message on the link
+// to the recipient. The message stays in flight until Deliver is called
+// or the scheduler delivers it.
 func (cl *Cluster) Send(from, to, msg string) error {
 	sender, recipient := cl.Get(from), cl.Get(to)
 	if sender == nil || recipient == nil {
 		return fmt.Errorf("node ids not in cluster: %s, %s", from, to)
 	}
-	sender.send(msg, recipient)
+	cl.tick()
+	if !sender.running() {
+		return errSystemDown
+	}
+	cl.post(sender.stampSend(msg, to))
+	return nil
+}
+
+// Multicast sends one message to a group of nodes. The sender records a
+// single send event and every recipient gets its own copy on its link, so
+// each one is delivered with its own latency and records its own recv.
+func (cl *Cluster) Multicast(from string, group []string, msg string) error {
+	sender := cl.Get(from)
+	if sender == nil {
+		return fmt.Errorf("node id not in cluster: %s", from)
+	}
+	for _, to := range group {
+		if cl.Get(to) == nil {
+			return fmt.Errorf("node id not in cluster: %s", to)
+		}
+	}
+	cl.tick()
+	if !sender.running() {
+		return errSystemDown
+	}
+	m := sender.stampSend(msg, "") // recipient is filled in per copy
+	for _, to := range group {
+		m.to = to
+		cl.post(m)
+	}
+	return nil
+}
+
+// Broadcast sends one message to every other node in the cluster.
+func (cl *Cluster) Broadcast(from, msg string) error {
+	group := make([]string, 0, len(cl.nodes))
+	for _, id := range cl.ids() {
+		if id != from {
+			group = append(group, id)
+		}
+	}
+	return cl.Multicast(from, group, msg)
+}
+
+// post puts a message on its link. With a scheduler attached, the delivery
+// is scheduled too.
+func (cl *Cluster) post(m message) {
+	m.ready = cl.step + cl.delay(m.from, m.to)
+	cl.link(m.from, m.to).push(m)
+	if cl.sched != nil {
+		cl.sched.deliverLater(m.from, m.to)
+	}
+}
+
+// onReceive registers a handler for messages delivered in the cluster.
+func (cl *Cluster) onReceive(h func(m message)) {
+	cl.handlers = append(cl.handlers, h)
+}
+
+// handle runs the handlers on a message a node delivered.
+func (cl *Cluster) handle(m message) {
+	for _, h := range cl.handlers {
+		h(m)
+	}
+}
+
+// SetNetwork changes the network model messages are delivered with.
+func (cl *Cluster) SetNetwork(n Network) { cl.net = n }
+
+// Internal generates an internal event on a node.
+func (cl *Cluster) Internal(id string) error {
+	no := cl.Get(id)
+	if no == nil {
+		return fmt.Errorf("node id not in cluster: %s", id)
+	}
+	cl.tick()
+	if !no.running() {
+		return errSystemDown
+	}
+	no.genInternalEvent()
+	return nil
+}
+
+// Deliver takes the oldest message in flight from one node to another
+// and hands it to the recipient.
+func (cl *Cluster) Deliver(from, to string) error {
+	if cl.Get(from) == nil || cl.Get(to) == nil {
+		return fmt.Errorf("node ids not in cluster: %s, %s", from, to)
+	}
+	cl.tick()
+	m, ok := cl.link(from, to).peek()
+	if !ok {
+		return errNoMessage
+	}
+	if m.ready > cl.step {
+		// the head of the link holds up everything behind it.
+		return errMessageDelayed
+	}
+	cl.link(from, to).pop()
+	if m.marker {
+		cl.recvMarker(from, to)
+		return nil
+	}
+	if reason, lost := cl.lost(m); lost {
+		cl.logFault(to, "drop", fmt.Sprintf("dropped message from %s (%s): %s", from, reason, m.msg))
+		return nil
+	}
+	if cl.snap != nil {
+		cl.snap.record(m)
+	}
+	// the message goes through the inbox so anything that got there
+	// first is processed first.
+	cl.nodes[to].inbox.put(m)
+	cl.nodes[to].receive()
 	return nil
 }
+
+// InFlight returns the number of messages on the link between two nodes.
+func (cl *Cluster) InFlight(from, to string) int {
+	if l, ok := cl.links[from][to]; ok {
+		return len(l.q)
+	}
+	return 0
+}
+
+// Drain delivers messages until there is nothing left in flight. Links are
+// visited in sorted order, one message each per round. Every attempt is a
+// step, so delayed messages become ready eventually.
+func (cl *Cluster) Drain() {
+	for {
+		inFlight := false
+		for _, from := range cl.ids() {
+			for _, to := range cl.ids() {
+				if cl.InFlight(from, to) > 0 {
+					cl.Deliver(from, to)
+					inFlight = true
+				}
+			}
+		}
+		if !inFlight {
+			return
+		}
+	}
+}
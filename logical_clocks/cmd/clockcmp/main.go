@@ -0,0 +1,39 @@
+// clockcmp runs the same random workload on a cluster once for every kind
+// of clock and prints how often each clock gets causality wrong.
+//
+//	go run ./cmd/clockcmp -nodes 5 -events 200 -seed 42
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	clocks "github.com/Joe-Degs/distributed_systems/logical_clocks"
+)
+
+func main() {
+	var w clocks.Workload
+	flag.IntVar(&w.Nodes, "nodes", 4, "nodes in the cluster")
+	flag.IntVar(&w.Events, "events", 100, "events in the workload, two thirds of them sends")
+	flag.DurationVar(&w.Window, "window", time.Second, "virtual time the events are spread over")
+	flag.Int64Var(&w.Seed, "seed", 1, "seed of the run, the same seed makes the same run")
+	flag.Parse()
+
+	results, err := clocks.CompareClocks(w)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "clockcmp:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("seed %d, %d nodes, %d events over %v\n\n", w.Seed, w.Nodes, w.Events, w.Window)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "clock\tevents\tpairs\tfalse positives\tfalse negatives\tconcurrent misclassified\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d/%d\t\n", r.Clock, r.Events, r.Pairs,
+			r.FalsePositives, r.FalseNegatives, r.ConcurrentMisclassified, r.Concurrent)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,116 @@
+package clocks
+
+import (
+	"fmt"
+	"time"
+)
+
+// The happens-before graph knows the truth, the clocks make a guess. Run
+// the same workload with every kind of clock and count how often the guess
+// is wrong, that's a number you can argue about instead of a wall of logs.
+
+// ClockAccuracy counts the ways a clock's HappensBefore disagrees with the
+// happens-before graph of a run. Every unordered pair of events is looked
+// at once, in both directions.
+type ClockAccuracy struct {
+	Clock  string
+	Events int
+	Pairs  int
+
+	FalsePositives int // the clock says a -> b but a doesn't happen before b
+	FalseNegatives int // a happens before b but the clock doesn't say so
+
+	// concurrent pairs the clock puts in some order, or can't make up its
+	// mind about because it says both orders at once.
+	Concurrent              int
+	ConcurrentMisclassified int
+}
+
+func (a ClockAccuracy) String() string {
+	return fmt.Sprintf("%s: %d events, %d pairs, %d false positives, %d false negatives, %d of %d concurrent pairs misclassified",
+		a.Clock, a.Events, a.Pairs, a.FalsePositives, a.FalseNegatives, a.ConcurrentMisclassified, a.Concurrent)
+}
+
+// Accuracy compares the recorded timestamps of every pair of events with
+// the graph.
+func (g *HBGraph) Accuracy() ClockAccuracy {
+	acc := ClockAccuracy{Events: len(g.events)}
+	for i := range g.events {
+		for j := i + 1; j < len(g.events); j++ {
+			acc.Pairs++
+			causal := g.relation(i, j)
+			clock := timestampRelation(g.events[i].timestamp, g.events[j].timestamp)
+
+			// a claim of unknown is a claim of both orders.
+			ab := clock == happensBefore || clock == unknown
+			ba := clock == happensAfter || clock == unknown
+			if ab && causal != happensBefore {
+				acc.FalsePositives++
+			}
+			if ba && causal != happensAfter {
+				acc.FalsePositives++
+			}
+			if causal == happensBefore && !ab || causal == happensAfter && !ba {
+				acc.FalseNegatives++
+			}
+			if causal == concurrent {
+				acc.Concurrent++
+				if clock != concurrent {
+					acc.ConcurrentMisclassified++
+				}
+			}
+		}
+	}
+	return acc
+}
+
+// Workload is a random run to compare clocks on. The same workload with
+// the same seed makes the same run whatever clock the nodes use.
+type Workload struct {
+	Nodes  int
+	Events int
+	Window time.Duration // virtual time the events are spread over
+	Seed   int64
+}
+
+// validate checks the workload can be run, a run needs two nodes to send
+// anything and a window to spread the events over.
+func (w Workload) validate() error {
+	switch {
+	case w.Nodes < 2:
+		return fmt.Errorf("workload needs at least 2 nodes, got %d", w.Nodes)
+	case w.Events < 0:
+		return fmt.Errorf("workload can't have %d events", w.Events)
+	case w.Window <= 0:
+		return fmt.Errorf("workload needs a positive window, got %v", w.Window)
+	}
+	return nil
+}
+
+// CompareClocks runs the workload once for every kind of clock and
+// reports how accurate each one was.
+func CompareClocks(w Workload) ([]ClockAccuracy, error) {
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	ids := make([]string, w.Nodes)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("n%d", i)
+	}
+	var results []ClockAccuracy
+	for _, ct := range ClockTypes() {
+		cl := NewCluster(ct.New, ids...)
+		s := NewScheduler(cl, w.Seed)
+		s.RandomWorkload(w.Events, w.Window)
+		s.Run()
+
+		g, err := cl.HappensBeforeGraph()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", ct.Name, err)
+		}
+		acc := g.Accuracy()
+		acc.Clock = ct.Name
+		results = append(results, acc)
+	}
+	return results, nil
+}
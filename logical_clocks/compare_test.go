@@ -0,0 +1,69 @@
+package clocks
+
+import (
+	"testing"
+	"time"
+)
+
+// nothingClock is a clock that doesn't keep track of anything, every
+// event gets the same timestamp. it's here to be registered in tests.
+type nothingClock struct{}
+
+func (nothingClock) Get() interface{}         { return nothingClock{} }
+func (nothingClock) Increment()               {}
+func (nothingClock) Merge(Clock)              {}
+func (nothingClock) HappensBefore(Clock) bool { return false }
+func (nothingClock) String() string           { return "-" }
+
+func TestCompareClocks(t *testing.T) {
+	defer func(saved []ClockType) { clockTypes = saved }(clockTypes)
+	RegisterClock(ClockType{
+		Name: "nothing",
+		New:  func() Clock { return nothingClock{} },
+		Restore: func(ts interface{}) (Clock, bool) {
+			c, ok := ts.(nothingClock)
+			return c, ok
+		},
+	})
+
+	results, err := CompareClocks(Workload{Nodes: 4, Events: 80, Window: time.Second, Seed: 11})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected a result for every clock, got %v", results)
+	}
+	for _, r := range results {
+		t.Log(r)
+	}
+
+	lamport, vector, nothing := results[0], results[1], results[2]
+	if lamport.Pairs != vector.Pairs || vector.Pairs != nothing.Pairs {
+		t.Error("expected the same run for every clock")
+	}
+	if lamport.FalseNegatives != 0 || lamport.FalsePositives == 0 {
+		t.Errorf("expected lamport clocks to never miss causality but make some up: %v", lamport)
+	}
+	if lamport.ConcurrentMisclassified != lamport.Concurrent {
+		t.Errorf("expected lamport clocks to order every concurrent pair: %v", lamport)
+	}
+	if vector.FalsePositives+vector.FalseNegatives+vector.ConcurrentMisclassified != 0 {
+		t.Errorf("expected vector clocks to get everything right: %v", vector)
+	}
+	if nothing.FalsePositives != 0 || nothing.FalseNegatives != nothing.Pairs-nothing.Concurrent {
+		t.Errorf("expected a clock that never orders anything to miss every causal pair: %v", nothing)
+	}
+}
+
+func TestCompareClocksBadWorkload(t *testing.T) {
+	for _, w := range []Workload{
+		{Nodes: -1, Events: 10, Window: time.Second},
+		{Nodes: 1, Events: 10, Window: time.Second},
+		{Nodes: 3, Events: -1, Window: time.Second},
+		{Nodes: 3, Events: 10},
+	} {
+		if _, err := CompareClocks(w); err == nil {
+			t.Errorf("expected an error for %+v", w)
+		}
+	}
+}
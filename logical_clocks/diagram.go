@@ -0,0 +1,44 @@
+package clocks
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Joe-Degs/distributed_systems/logical_clocks/spacetime"
+)
+
+// Diagram turns the logs of the cluster into a space-time diagram, faults
+// are not events of the nodes and are left out.
+func (cl *Cluster) Diagram() spacetime.Diagram {
+	d := spacetime.Diagram{Nodes: cl.everyone()}
+	for _, id := range d.Nodes {
+		for _, e := range cl.logOf(id) {
+			d.Events = append(d.Events, spacetime.Event{
+				Node:  id,
+				Seq:   e.seq,
+				Kind:  e.status,
+				Label: formatTimestamp(e.timestamp),
+			})
+			if e.status == "recv" {
+				d.Messages = append(d.Messages, spacetime.Message{
+					From:  spacetime.EventRef{Node: e.from.Node, Seq: e.from.Seq},
+					To:    spacetime.EventRef{Node: id, Seq: e.seq},
+					Label: logMsg(e),
+				})
+			}
+		}
+	}
+	return d
+}
+
+// WriteSVG draws the space-time diagram of the cluster as SVG.
+func (cl *Cluster) WriteSVG(w io.Writer) error { return cl.Diagram().WriteSVG(w) }
+
+// WriteDOT writes the space-time diagram of the cluster as Graphviz DOT.
+func (cl *Cluster) WriteDOT(w io.Writer) error { return cl.Diagram().WriteDOT(w) }
+
+// formatTimestamp prints vectors without the map in front.
+func formatTimestamp(ts interface{}) string {
+	return strings.TrimPrefix(fmt.Sprint(ts), "map")
+}
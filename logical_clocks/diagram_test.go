@@ -0,0 +1,39 @@
+package clocks
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestClusterDiagram(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b", "c")
+	cl.Send("a", "b", "hello")
+	cl.Internal("c")
+	cl.Drain()
+	cl.Broadcast("b", "hi all")
+	cl.Drain()
+
+	d := cl.Diagram()
+	if len(d.Events) != 6 || len(d.Messages) != 3 {
+		t.Fatalf("expected 6 events and 3 messages, got %d and %d", len(d.Events), len(d.Messages))
+	}
+	if d.Events[0].Label != "[a:1 b:0 c:0]" {
+		t.Errorf("expected vectors to be printed without map, got %s", d.Events[0].Label)
+	}
+
+	var svg bytes.Buffer
+	if err := cl.WriteSVG(&svg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(svg.String(), "<title>hi all</title>") {
+		t.Error("expected the broadcast in the drawing")
+	}
+	var dot bytes.Buffer
+	if err := cl.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(dot.String(), "style=dashed"); n != 3 {
+		t.Errorf("expected 3 messages in the graph, got %d", n)
+	}
+}
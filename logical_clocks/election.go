@@ -0,0 +1,295 @@
+package clocks
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Leader election, the cluster has to agree on one node to be in charge and
+// pick a new one when that node goes down. Both algorithms here pick the
+// live node with the biggest id, they differ in how they get the word around.
+// They need timeouts to notice crashed nodes, so they run on a scheduler.
+
+// The bully algorithm.
+//
+// -> a node that wants an election sends ELECTION to every node with a
+//    bigger id.
+// -> a node that gets ELECTION from a smaller id answers OK and starts an
+//    election of its own, it is bullying the smaller one out of the race.
+// -> if nobody answers OK in time, the node wins and sends COORDINATOR to
+//    everyone. if someone answered but no COORDINATOR shows up in time, the
+//    node that answered probably crashed and the election starts over.
+
+const bullyMsg = "bully "
+
+// Bully runs the bully algorithm on a cluster.
+type Bully struct {
+	cl    *Cluster
+	s     *Scheduler
+	state map[string]*bullyState
+}
+
+type bullyState struct {
+	leader   string
+	electing bool
+	gotOK    bool
+	round    int // timers of older rounds are ignored
+}
+
+// NewBully starts the bully algorithm on a cluster driven by the scheduler.
+func NewBully(cl *Cluster, s *Scheduler) *Bully {
+	b := &Bully{cl: cl, s: s, state: make(map[string]*bullyState)}
+	for id := range cl.nodes {
+		b.state[id] = &bullyState{}
+	}
+	cl.onReceive(b.receive)
+	return b
+}
+
+// Leader returns the leader the node knows about, empty if it knows none.
+func (b *Bully) Leader(id string) string {
+	if st, ok := b.state[id]; ok {
+		return st.leader
+	}
+	return ""
+}
+
+// Start makes a node start an election.
+func (b *Bully) Start(id string) error {
+	if b.cl.Get(id) == nil {
+		return fmt.Errorf("node id not in cluster: %s", id)
+	}
+	st := b.stateOf(id)
+	if !b.cl.up(id) {
+		return errSystemDown
+	}
+	st.round++
+	st.electing, st.gotOK = true, false
+
+	var higher []string
+	for _, other := range b.cl.ids() {
+		if other > id {
+			higher = append(higher, other)
+		}
+	}
+	if len(higher) == 0 {
+		b.win(id)
+		return nil
+	}
+	b.cl.Multicast(id, higher, bullyMsg+"election")
+
+	round := st.round
+	b.s.After(roundTrip(b.cl), func() {
+		if st.round != round || !b.cl.up(id) {
+			return
+		}
+		if !st.gotOK {
+			b.win(id)
+			return
+		}
+		// someone bigger is alive, give it time to take over.
+		b.s.After(roundTrip(b.cl), func() {
+			if st.round == round && st.electing && b.cl.up(id) {
+				b.Start(id)
+			}
+		})
+	})
+	return nil
+}
+
+// stateOf returns the state of a node, nodes that joined after the
+// algorithm started get theirs the first time they show up.
+func (b *Bully) stateOf(id string) *bullyState {
+	st, ok := b.state[id]
+	if !ok {
+		st = &bullyState{}
+		b.state[id] = st
+	}
+	return st
+}
+
+// win makes the node the leader and tells everyone else.
+func (b *Bully) win(id string) {
+	st := b.stateOf(id)
+	st.leader, st.electing = id, false
+	st.round++
+	b.cl.Broadcast(id, bullyMsg+"coordinator")
+}
+
+func (b *Bully) receive(m message) {
+	if !strings.HasPrefix(m.msg, bullyMsg) {
+		return
+	}
+	st := b.stateOf(m.to)
+	switch strings.TrimPrefix(m.msg, bullyMsg) {
+	case "election":
+		b.cl.Send(m.to, m.from, bullyMsg+"ok")
+		if !st.electing {
+			b.Start(m.to)
+		}
+	case "ok":
+		st.gotOK = true
+	case "coordinator":
+		if m.from < m.to {
+			// a smaller node thinks its in charge, bully it.
+			b.Start(m.to)
+			return
+		}
+		st.leader, st.electing = m.from, false
+		st.round++
+	}
+}
+
+// The Chang-Roberts ring algorithm.
+//
+// -> the node that starts the election sends its id to the next node.
+// -> a node that gets a bigger id than its own passes it on. a smaller id
+//    is replaced with its own, unless it already sent its own id around,
+//    then the message is swallowed.
+// -> a node that gets its own id back has the biggest id on the ring, it
+//    is the leader and sends ELECTED around the ring.
+//
+// Crashed nodes are skipped when passing messages on. A message can still
+// be lost to a node that crashed while it was in flight, so the node that
+// started the election starts a new round if nothing comes back in time,
+// and the leader sends ELECTED again if it doesn't come back around.
+
+const changRobertsMsg = "chang-roberts "
+
+// ChangRoberts runs the Chang-Roberts election on a ring of nodes.
+type ChangRoberts struct {
+	cl    *Cluster
+	s     *Scheduler
+	ring  []string
+	state map[string]*ringState
+}
+
+type ringState struct {
+	leader      string
+	round       int  // newest round of election the node has seen
+	participant bool // sent its own id around in this round
+	done        bool // the node saw ELECTED in this round
+}
+
+// NewChangRoberts starts the algorithm on the nodes of the ring, in order.
+func NewChangRoberts(cl *Cluster, s *Scheduler, ring ...string) (*ChangRoberts, error) {
+	cr := &ChangRoberts{cl: cl, s: s, ring: ring, state: make(map[string]*ringState)}
+	for _, id := range ring {
+		if cl.Get(id) == nil {
+			return nil, fmt.Errorf("node id not in cluster: %s", id)
+		}
+		cr.state[id] = &ringState{}
+	}
+	cl.onReceive(cr.receive)
+	return cr, nil
+}
+
+// Leader returns the leader the node knows about, empty if it knows none.
+func (cr *ChangRoberts) Leader(id string) string {
+	if st, ok := cr.state[id]; ok {
+		return st.leader
+	}
+	return ""
+}
+
+// next returns the first live node after id on the ring.
+func (cr *ChangRoberts) next(id string) string {
+	at := 0
+	for i, other := range cr.ring {
+		if other == id {
+			at = i
+		}
+	}
+	for i := 1; i < len(cr.ring); i++ {
+		other := cr.ring[(at+i)%len(cr.ring)]
+		if cr.cl.up(other) {
+			return other
+		}
+	}
+	return id
+}
+
+// Start makes a node start a new round of election.
+func (cr *ChangRoberts) Start(id string) error {
+	st, ok := cr.state[id]
+	if !ok {
+		return fmt.Errorf("node id not in cluster: %s", id)
+	}
+	if !cr.cl.up(id) {
+		return errSystemDown
+	}
+	round := st.round + 1
+	cr.enter(id, round)
+	st.participant = true
+	cr.pass(id, "election", round, id)
+
+	// the whole ring has to be walked twice.
+	timeout := time.Duration(2*len(cr.ring)) * roundTrip(cr.cl)
+	cr.s.After(timeout, func() {
+		if st.round == round && !st.done && cr.cl.up(id) {
+			cr.Start(id)
+		}
+	})
+	return nil
+}
+
+// enter moves a node to a newer round.
+func (cr *ChangRoberts) enter(id string, round int) {
+	st := cr.state[id]
+	if round > st.round {
+		st.round, st.participant, st.done = round, false, false
+	}
+}
+
+func (cr *ChangRoberts) pass(from, kind string, round int, candidate string) {
+	cr.cl.Send(from, cr.next(from), fmt.Sprintf("%s%s %d %s", changRobertsMsg, kind, round, candidate))
+}
+
+func (cr *ChangRoberts) receive(m message) {
+	if !strings.HasPrefix(m.msg, changRobertsMsg) {
+		return
+	}
+	var kind, candidate string
+	var round int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(m.msg, changRobertsMsg), "%s %d %s", &kind, &round, &candidate); err != nil {
+		return
+	}
+	st, ok := cr.state[m.to]
+	if !ok || round < st.round {
+		return // left over from an older round
+	}
+	cr.enter(m.to, round)
+
+	switch kind {
+	case "election":
+		switch {
+		case candidate > m.to:
+			st.participant = true
+			cr.pass(m.to, "election", round, candidate)
+		case candidate < m.to && !st.participant:
+			st.participant = true
+			cr.pass(m.to, "election", round, m.to)
+		case candidate == m.to:
+			cr.elected(m.to, round)
+		}
+	case "elected":
+		st.leader, st.done = candidate, true
+		if candidate != m.to {
+			cr.pass(m.to, "elected", round, candidate)
+		}
+	}
+}
+
+// elected makes the node the leader and sends ELECTED around until it
+// makes it all the way back.
+func (cr *ChangRoberts) elected(id string, round int) {
+	st := cr.state[id]
+	st.leader = id
+	cr.pass(id, "elected", round, id)
+	cr.s.After(time.Duration(len(cr.ring))*roundTrip(cr.cl), func() {
+		if st.round == round && !st.done && cr.cl.up(id) {
+			cr.elected(id, round)
+		}
+	})
+}
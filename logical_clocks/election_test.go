@@ -0,0 +1,110 @@
+package clocks
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkLeader checks every live node agrees on the leader.
+func checkLeader(t *testing.T, cl *Cluster, leader func(string) string, want string) {
+	t.Helper()
+	for _, id := range cl.ids() {
+		if !cl.nodes[id].status {
+			continue
+		}
+		if got := leader(id); got != want {
+			t.Errorf("%s thinks the leader is %q, expected %q", id, got, want)
+		}
+	}
+}
+
+// countElectionMsgs counts the sends of an election algorithm in the log.
+func countElectionMsgs(cl *Cluster, prefix string) int {
+	cl.appendLogs()
+	n := 0
+	for _, e := range cl.dlog {
+		if e.status == "send" && strings.HasPrefix(logMsg(e), prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestBullyElectsBiggestId(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b", "c", "d", "e")
+	s := NewScheduler(cl, 7)
+	b := NewBully(cl, s)
+
+	s.At(0, func() { b.Start("a") })
+	s.Run()
+	checkLeader(t, cl, b.Leader, "e")
+	if countElectionMsgs(cl, bullyMsg) == 0 {
+		t.Error("expected election messages in the log")
+	}
+}
+
+func TestBullyCrashDuringElection(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b", "c", "d", "e")
+	s := NewScheduler(cl, 7)
+	b := NewBully(cl, s)
+
+	// e goes down before a's election reaches it, d goes down after it
+	// answered some of the others but before it could take over.
+	s.At(0, func() { b.Start("a") })
+	s.Fault(time.Millisecond, Fault{Kind: Crash, Nodes: []string{"e"}})
+	s.Fault(60*time.Millisecond, Fault{Kind: Crash, Nodes: []string{"d"}})
+	s.Run()
+	checkLeader(t, cl, b.Leader, "c")
+
+	// d comes back and bullies its way to the top.
+	s.Fault(s.Now(), Fault{Kind: Recover, Nodes: []string{"d"}})
+	s.At(s.Now(), func() { b.Start("d") })
+	s.Run()
+	checkLeader(t, cl, b.Leader, "d")
+
+	if _, err := cl.HappensBeforeGraph(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChangRobertsElectsBiggestId(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b", "c", "d", "e")
+	s := NewScheduler(cl, 7)
+	cr, err := NewChangRoberts(cl, s, "c", "a", "e", "b", "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.At(0, func() { cr.Start("a") })
+	s.At(0, func() { cr.Start("b") })
+	s.Run()
+	checkLeader(t, cl, cr.Leader, "e")
+	if countElectionMsgs(cl, changRobertsMsg) == 0 {
+		t.Error("expected election messages in the log")
+	}
+}
+
+func TestChangRobertsCrashDuringElection(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b", "c", "d", "e")
+	s := NewScheduler(cl, 7)
+	cr, err := NewChangRoberts(cl, s, "a", "b", "c", "d", "e")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b crashes with a's message on its way to it, so the first round is
+	// lost and a has to start over. e is gone before the message gets
+	// around to it.
+	s.At(0, func() { cr.Start("a") })
+	s.Fault(time.Millisecond, Fault{Kind: Crash, Nodes: []string{"b", "e"}})
+	s.Run()
+	checkLeader(t, cl, cr.Leader, "d")
+	if cr.state["a"].round < 2 {
+		t.Errorf("expected a to start a second round, it is on round %d", cr.state["a"].round)
+	}
+
+	if _, err := NewChangRoberts(cl, s, "a", "z"); err == nil {
+		t.Error("expected an error for a ring with a node not in the cluster")
+	}
+}
@@ -0,0 +1,181 @@
+package clocks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Nodes crash, networks split in two and links lose or hold up messages.
+// The comments on lamport clocks talk about nodes that stop talking to each
+// other and get back together later, the fault schedule is how we make that
+// happen in the cluster and watch what the clocks do about it.
+//
+// Faults are scheduled at logical steps of the cluster, every Send, Deliver
+// and Internal is one step. Faults that happen are recorded and merged into
+// the cluster log with the events of the nodes.
+
+// kinds of faults.
+const (
+	Crash     = "crash"     // nodes stop, they can't send and lose messages sent to them
+	Recover   = "recover"   // crashed nodes come back with the clock they had
+	Partition = "partition" // nodes are cut off from the rest of the cluster
+	Heal      = "heal"      // partitions and link faults go away
+	Drop      = "drop"      // messages on a link are lost
+	Delay     = "delay"     // messages on a link are held up
+)
+
+// Fault is something that goes wrong in the cluster at a given step.
+type Fault struct {
+	Step  int
+	Kind  string
+	Nodes []string // nodes that crash, recover or get partitioned off
+
+	// link affected by drop and delay faults.
+	From, To string
+	Count    int // messages to drop, 0 drops everything until healed
+	Steps    int // how many steps delayed messages are held up
+}
+
+func (f Fault) String() string {
+	switch f.Kind {
+	case Drop, Delay:
+		return fmt.Sprintf("%s %s -> %s at step %d", f.Kind, f.From, f.To, f.Step)
+	}
+	return fmt.Sprintf("%s %v at step %d", f.Kind, f.Nodes, f.Step)
+}
+
+// linkFault is the state of drop and delay faults on a link.
+type linkFault struct {
+	drop    int  // messages left to drop
+	dropAll bool // drop everything until healed
+	delay   int
+}
+
+// isFault tells fault entries apart from the events of the nodes.
+func isFault(status string) bool {
+	switch status {
+	case Crash, Recover, Partition, Heal, Drop, Delay:
+		return true
+	}
+	return false
+}
+
+// Schedule adds faults to the fault schedule of the cluster.
+func (cl *Cluster) Schedule(faults ...Fault) {
+	cl.faults = append(cl.faults, faults...)
+	sort.SliceStable(cl.faults, func(i, j int) bool {
+		return cl.faults[i].Step < cl.faults[j].Step
+	})
+}
+
+// Step returns the current logical step of the cluster.
+func (cl *Cluster) Step() int { return cl.step }
+
+// tick moves the cluster one step forward and applies the faults that
+// are due.
+func (cl *Cluster) tick() {
+	cl.step++
+	for len(cl.faults) > 0 && cl.faults[0].Step <= cl.step {
+		cl.apply(cl.faults[0])
+		cl.faults = cl.faults[1:]
+	}
+}
+
+func (cl *Cluster) apply(f Fault) {
+	switch f.Kind {
+	case Crash, Recover:
+		msg := "node crashed"
+		if f.Kind == Recover {
+			msg = "node recovered"
+		}
+		for _, id := range f.Nodes {
+			if no := cl.Get(id); no != nil {
+				no.changeStatus(f.Kind == Recover)
+				cl.logFault(id, f.Kind, msg)
+			}
+		}
+	case Partition:
+		cl.groups++
+		for _, id := range f.Nodes {
+			cl.partition[id] = cl.groups
+		}
+		for _, id := range cl.ids() {
+			cl.logFault(id, Partition, fmt.Sprintf("partitioned [%s] from the rest", strings.Join(f.Nodes, " ")))
+		}
+	case Heal:
+		cl.partition = make(map[string]int)
+		cl.linkFaults = make(map[string]map[string]*linkFault)
+		for _, id := range cl.ids() {
+			cl.logFault(id, Heal, "network healed")
+		}
+	case Drop:
+		lf := cl.linkFault(f.From, f.To)
+		lf.drop, lf.dropAll = f.Count, f.Count == 0
+		cl.logFault(f.From, Drop, fmt.Sprintf("link to %s dropping messages", f.To))
+	case Delay:
+		cl.linkFault(f.From, f.To).delay = f.Steps
+		cl.logFault(f.From, Delay, fmt.Sprintf("link to %s delaying messages by %d steps", f.To, f.Steps))
+	}
+}
+
+func (cl *Cluster) linkFault(from, to string) *linkFault {
+	if cl.linkFaults[from] == nil {
+		cl.linkFaults[from] = make(map[string]*linkFault)
+	}
+	lf, ok := cl.linkFaults[from][to]
+	if !ok {
+		lf = &linkFault{}
+		cl.linkFaults[from][to] = lf
+	}
+	return lf
+}
+
+// delay returns how many steps a message sent now on a link is held up.
+func (cl *Cluster) delay(from, to string) int {
+	if lf, ok := cl.linkFaults[from][to]; ok {
+		return lf.delay
+	}
+	return 0
+}
+
+// lost reports whether a message is lost on its way and why.
+func (cl *Cluster) lost(m message) (string, bool) {
+	if !cl.nodes[m.to].running() {
+		return "node down", true
+	}
+	if cl.partition[m.from] != cl.partition[m.to] {
+		return "partition", true
+	}
+	if lf, ok := cl.linkFaults[m.from][m.to]; ok {
+		if lf.dropAll {
+			return "link down", true
+		}
+		if lf.drop > 0 {
+			lf.drop--
+			return "link drop", true
+		}
+	}
+	return "", false
+}
+
+// logFault records a fault seen by a node, stamped with the node's clock.
+// the clock is not touched, a fault is not an event of the node.
+func (cl *Cluster) logFault(id, kind, msg string) {
+	no := cl.nodes[id]
+	nth := 1
+	for _, e := range cl.flog {
+		if e.nodeId == id {
+			nth++
+		}
+	}
+	cl.flog = append(cl.flog, eventLog{
+		nodeId: id,
+		msg: fmt.Sprintf("[nodeId -> %s] [msg -> %s] [timestamp -> %v] [event_type -> %s]",
+			id, msg, no.Get(), kind),
+		status:    kind,
+		timestamp: copyTimestamp(no.Get()),
+		seq:       len(no.log),
+		fault:     nth,
+	})
+}
@@ -0,0 +1,160 @@
+package clocks
+
+import (
+	"errors"
+	"testing"
+)
+
+// countFaults counts the entries of a given kind in the cluster log.
+func countFaults(cl *Cluster, kind string) int {
+	n := 0
+	for _, e := range cl.dlog {
+		if e.status == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFaultCrashAndRecover(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b")
+	cl.Schedule(
+		Fault{Step: 5, Kind: Recover, Nodes: []string{"b"}},
+		Fault{Step: 2, Kind: Crash, Nodes: []string{"b"}},
+	)
+
+	cl.Send("a", "b", "m1")
+	cl.Deliver("a", "b") // b crashes right before, m1 is lost
+	if err := cl.Send("b", "a", "from the dead"); !errors.Is(err, errSystemDown) {
+		t.Errorf("expected crashed node to not send, got %v", err)
+	}
+	if err := cl.Internal("b"); !errors.Is(err, errSystemDown) {
+		t.Errorf("expected crashed node to not generate events, got %v", err)
+	}
+	cl.Send("a", "b", "m2") // b is back
+	cl.Deliver("a", "b")
+
+	if cl.Step() != 6 {
+		t.Errorf("expected 6 steps, got %d", cl.Step())
+	}
+	if len(cl.nodes["b"].log) != 1 || logMsg(cl.nodes["b"].log[0]) != "m2" {
+		t.Errorf("expected b to only receive m2, got %v", cl.nodes["b"].log)
+	}
+
+	cl.appendLogs()
+	for _, kind := range []string{Crash, Drop, Recover} {
+		if countFaults(cl, kind) != 1 {
+			t.Errorf("expected one %s entry in the logs, got %d", kind, countFaults(cl, kind))
+		}
+	}
+	if _, err := cl.HappensBeforeGraph(); err != nil {
+		t.Errorf("faults in the logs should not break the graph: %v", err)
+	}
+}
+
+// faults land in the node's log between its events, they must not take
+// the id of the event that comes after them.
+func TestFaultIdsApartFromEvents(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b")
+	cl.Schedule(
+		Fault{Step: 2, Kind: Crash, Nodes: []string{"b"}},
+		Fault{Step: 3, Kind: Recover, Nodes: []string{"b"}},
+	)
+	cl.Internal("b")
+	cl.Internal("a") // b crashes
+	cl.Internal("a") // b recovers
+	cl.Internal("b")
+
+	cl.appendLogs()
+	seen := make(map[EventId]string)
+	for _, e := range cl.dlog {
+		if other, ok := seen[e.id()]; ok {
+			t.Errorf("%s entry has the id %s of a %s entry", e.status, e.id(), other)
+		}
+		seen[e.id()] = e.status
+	}
+	if len(seen) != 6 {
+		t.Errorf("expected 4 events and 2 faults, got %v", seen)
+	}
+	if _, err := cl.HappensBeforeGraph(); err != nil {
+		t.Error(err)
+	}
+}
+
+// a and b can't reach c for a while, everyone keeps doing their own thing
+// and then they get back together.
+func partitionRun(clock func() Clock) *Cluster {
+	cl := NewCluster(clock, "a", "b", "c")
+	cl.Schedule(
+		Fault{Step: 1, Kind: Partition, Nodes: []string{"c"}},
+		Fault{Step: 10, Kind: Heal},
+	)
+	cl.Send("a", "c", "lost in the partition")
+	cl.Send("a", "b", "hi b")
+	cl.Deliver("a", "c")
+	cl.Deliver("a", "b")
+	for i := 0; i < 4; i++ {
+		cl.Internal("c")
+	}
+	cl.Internal("b")
+	cl.Send("c", "a", "i'm back")
+	cl.Drain()
+	return cl
+}
+
+func TestFaultPartitionAndHeal(t *testing.T) {
+	cl := partitionRun(NewVectorClock)
+	cl.appendLogs()
+	if countFaults(cl, Partition) != 3 || countFaults(cl, Heal) != 3 {
+		t.Errorf("expected every node to log the partition and the heal")
+	}
+	if countFaults(cl, Drop) != 1 {
+		t.Errorf("expected the message across the partition to be dropped")
+	}
+	if len(cl.nodes["a"].log) != 3 {
+		t.Errorf("expected c's message to reach a after the heal, got %v", cl.nodes["a"].log)
+	}
+
+	g, err := cl.HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := g.CheckClocks(); len(m) != 0 {
+		t.Errorf("vector clocks should survive the partition: %v", m)
+	}
+
+	// c's busy time alone in the partition makes lamport clocks put its
+	// events after things that happened on the other side.
+	g, err = partitionRun(NewLamportClock).HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.CheckClocks()) == 0 {
+		t.Errorf("expected lamport clocks to order concurrent events across the partition")
+	}
+}
+
+func TestFaultDropAndDelay(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b")
+	cl.Schedule(
+		Fault{Step: 1, Kind: Drop, From: "a", To: "b", Count: 1},
+		Fault{Step: 1, Kind: Delay, From: "b", To: "a", Steps: 3},
+	)
+
+	cl.Send("a", "b", "dropped")
+	cl.Send("a", "b", "kept")
+	cl.Deliver("a", "b")
+	cl.Deliver("a", "b")
+	if len(cl.nodes["b"].log) != 1 || logMsg(cl.nodes["b"].log[0]) != "kept" {
+		t.Errorf("expected only the second message to arrive, got %v", cl.nodes["b"].log)
+	}
+
+	cl.Send("b", "a", "slow") // step 5, ready at step 8
+	if err := cl.Deliver("b", "a"); !errors.Is(err, errMessageDelayed) {
+		t.Errorf("expected message to be held up, got %v", err)
+	}
+	cl.Drain()
+	if cl.Step() != 8 || len(cl.nodes["a"].log) != 3 {
+		t.Errorf("expected slow message at step 8, got step %d with log %v", cl.Step(), cl.nodes["a"].log)
+	}
+}
@@ -0,0 +1,292 @@
+package clocks
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Staring at t.Log output to see if clocks are doing the right thing gets
+// old really fast. The logs of the cluster already have everything needed
+// to know the true causality of events, events on the same node are ordered
+// by the position in the log (program order) and every recv points back to
+// the send that carried its message. Put the two together and you get the
+// happens-before graph, and the graph is the ground truth the clocks are
+// trying to capture.
+
+// relations between two events, same words getCausalRelation uses.
+const (
+	happensBefore = "happens-before"
+	happensAfter  = "happens-after"
+	concurrent    = "concurrent"
+	unknown       = "unknown"
+)
+
+// Edge is a direct happens-before edge between two events.
+type Edge struct {
+	From, To EventId
+}
+
+func (e Edge) String() string { return fmt.Sprintf("%s -> %s", e.From, e.To) }
+
+// ClockMismatch is a pair of events whose recorded timestamps tell a
+// different story from the happens-before graph.
+type ClockMismatch struct {
+	A, B   EventId
+	Causal string // relation of A to B in the graph
+	Clock  string // relation of A to B according to the timestamps
+}
+
+func (m ClockMismatch) String() string {
+	return fmt.Sprintf("%s %s %s but clock says %s", m.A, m.Causal, m.B, m.Clock)
+}
+
+// HBGraph is the happens-before DAG of the events recorded in a cluster.
+type HBGraph struct {
+	events []eventLog
+	index  map[EventId]int
+	succ   [][]int  // direct edges
+	reach  []bitset // reach[i] has j set if i -> j
+}
+
+// HappensBeforeGraph consolidates the logs of the cluster and builds
+// the happens-before graph from them.
+func (cl *Cluster) HappensBeforeGraph() (*HBGraph, error) {
+	cl.appendLogs()
+	return newHBGraph(cl.dlog)
+}
+
+// newHBGraph builds the graph with program order edges between
+// consecutive events of a node and send -> recv edges. Faults in the
+// logs are not events and are left out.
+func newHBGraph(dlog []eventLog) (*HBGraph, error) {
+	g := &HBGraph{index: make(map[EventId]int)}
+	for _, e := range dlog {
+		if isFault(e.status) {
+			continue
+		}
+		if _, ok := g.index[e.id()]; ok {
+			return nil, fmt.Errorf("duplicate event in logs: %s", e.id())
+		}
+		g.index[e.id()] = len(g.events)
+		g.events = append(g.events, e)
+	}
+	g.succ = make([][]int, len(g.events))
+
+	// program order, the previous event on the same node.
+	byNode := make(map[string][]int)
+	for i, e := range g.events {
+		byNode[e.nodeId] = append(byNode[e.nodeId], i)
+	}
+	for _, idx := range byNode {
+		sort.Slice(idx, func(a, b int) bool {
+			return g.events[idx[a]].seq < g.events[idx[b]].seq
+		})
+		for i := 1; i < len(idx); i++ {
+			g.succ[idx[i-1]] = append(g.succ[idx[i-1]], idx[i])
+		}
+	}
+
+	// messages, from send to the corresponding recv.
+	for i, e := range g.events {
+		if e.status != "recv" {
+			continue
+		}
+		s, ok := g.index[e.from]
+		if !ok {
+			return nil, fmt.Errorf("recv %s has no matching send %s", e.id(), e.from)
+		}
+		g.succ[s] = append(g.succ[s], i)
+	}
+
+	order, err := g.topoOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	// walk backwards so every successor is done before its predecessors.
+	g.reach = make([]bitset, len(g.events))
+	for i := len(order) - 1; i >= 0; i-- {
+		u := order[i]
+		g.reach[u] = newBitset(len(g.events))
+		for _, v := range g.succ[u] {
+			g.reach[u].set(v)
+			g.reach[u].or(g.reach[v])
+		}
+	}
+	return g, nil
+}
+
+// topoOrder returns the events in topological order using kahn's algorithm.
+func (g *HBGraph) topoOrder() ([]int, error) {
+	indeg := make([]int, len(g.events))
+	for _, s := range g.succ {
+		for _, v := range s {
+			indeg[v]++
+		}
+	}
+	queue := make([]int, 0, len(g.events))
+	for i, d := range indeg {
+		if d == 0 {
+			queue = append(queue, i)
+		}
+	}
+	for i := 0; i < len(queue); i++ {
+		for _, v := range g.succ[queue[i]] {
+			indeg[v]--
+			if indeg[v] == 0 {
+				queue = append(queue, v)
+			}
+		}
+	}
+	if len(queue) != len(g.events) {
+		return nil, fmt.Errorf("happens-before graph has a cycle")
+	}
+	return queue, nil
+}
+
+// Events returns the ids of all events in the graph.
+func (g *HBGraph) Events() []EventId {
+	ids := make([]EventId, len(g.events))
+	for i, e := range g.events {
+		ids[i] = e.id()
+	}
+	return ids
+}
+
+// Before reports whether a -> b.
+func (g *HBGraph) Before(a, b EventId) bool {
+	i, ok := g.index[a]
+	j, ok2 := g.index[b]
+	if !ok || !ok2 {
+		return false
+	}
+	return g.reach[i].has(j)
+}
+
+// Concurrent reports whether a || b, that is neither a -> b nor b -> a.
+// an event is not concurrent with itself.
+func (g *HBGraph) Concurrent(a, b EventId) bool {
+	if a == b {
+		return false
+	}
+	_, ok := g.index[a]
+	_, ok2 := g.index[b]
+	return ok && ok2 && !g.Before(a, b) && !g.Before(b, a)
+}
+
+// ConcurrentWith returns all events concurrent with a.
+func (g *HBGraph) ConcurrentWith(a EventId) []EventId {
+	var ids []EventId
+	for _, e := range g.events {
+		if g.Concurrent(a, e.id()) {
+			ids = append(ids, e.id())
+		}
+	}
+	return ids
+}
+
+// Reduction returns the transitive reduction of the graph, the smallest set
+// of edges with the same reachability. Its what you want to draw, the full
+// closure is a mess of arrows.
+func (g *HBGraph) Reduction() []Edge {
+	var edges []Edge
+	for u, succ := range g.succ {
+		for _, v := range succ {
+			// u -> v is redundant if v can be reached through
+			// some other successor of u.
+			redundant := false
+			for _, w := range succ {
+				if w != v && g.reach[w].has(v) {
+					redundant = true
+					break
+				}
+			}
+			if !redundant {
+				edges = append(edges, Edge{g.events[u].id(), g.events[v].id()})
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return lessEventId(edges[i].From, edges[j].From)
+		}
+		return lessEventId(edges[i].To, edges[j].To)
+	})
+	return edges
+}
+
+// relation returns the relation of event i to event j in the graph.
+func (g *HBGraph) relation(i, j int) string {
+	switch {
+	case g.reach[i].has(j):
+		return happensBefore
+	case g.reach[j].has(i):
+		return happensAfter
+	}
+	return concurrent
+}
+
+// CheckClocks compares the recorded timestamps of every pair of events
+// with the graph and returns the pairs where they disagree.
+func (g *HBGraph) CheckClocks() []ClockMismatch {
+	var mismatches []ClockMismatch
+	for i := range g.events {
+		for j := i + 1; j < len(g.events); j++ {
+			causal := g.relation(i, j)
+			clock := timestampRelation(g.events[i].timestamp, g.events[j].timestamp)
+			if causal != clock {
+				mismatches = append(mismatches, ClockMismatch{
+					A:      g.events[i].id(),
+					B:      g.events[j].id(),
+					Causal: causal,
+					Clock:  clock,
+				})
+			}
+		}
+	}
+	return mismatches
+}
+
+// timestampRelation asks the clocks HappensBefore about a and b.
+// a clock claiming both orders at once can't tell, so its unknown.
+// vectors are compared directly, going through VectorClock formats
+// them to strings and that is too slow for every pair of a big run.
+func timestampRelation(a, b interface{}) string {
+	var ab, ba bool
+	if _, ok := a.(map[string]int); ok {
+		ab, ba = vectorLess(a, b), vectorLess(b, a)
+	} else {
+		ab = clockOf(a).HappensBefore(clockOf(b))
+		ba = clockOf(b).HappensBefore(clockOf(a))
+	}
+	switch {
+	case ab && ba:
+		return unknown
+	case ab:
+		return happensBefore
+	case ba:
+		return happensAfter
+	}
+	return concurrent
+}
+
+func lessEventId(a, b EventId) bool {
+	if a.Node != b.Node {
+		return a.Node < b.Node
+	}
+	return a.Seq < b.Seq
+}
+
+// bitset is a fixed size set of small integers.
+type bitset []uint64
+
+func newBitset(n int) bitset { return make(bitset, (n+63)/64) }
+
+func (b bitset) set(i int)      { b[i/64] |= 1 << uint(i%64) }
+func (b bitset) has(i int) bool { return b[i/64]&(1<<uint(i%64)) != 0 }
+
+func (b bitset) or(o bitset) {
+	for i := range b {
+		b[i] |= o[i]
+	}
+}
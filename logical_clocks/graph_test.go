@@ -0,0 +1,103 @@
+package clocks
+
+import (
+	"testing"
+)
+
+// a: internal, send to b
+// b: recv from a, internal
+// c: internal
+func graphCluster(clock func() Clock) *Cluster {
+	cl := NewCluster(clock, "a", "b", "c")
+	cl.nodes["a"].genInternalEvent()
+	cl.nodes["a"].send("hello from a", cl.nodes["b"])
+	cl.nodes["b"].drain()
+	cl.nodes["b"].genInternalEvent()
+	cl.nodes["c"].genInternalEvent()
+	return cl
+}
+
+func TestHappensBeforeGraph(t *testing.T) {
+	g, err := graphCluster(NewVectorClock).HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a0, a1 := EventId{"a", 0}, EventId{"a", 1}
+	b0, b1 := EventId{"b", 0}, EventId{"b", 1}
+	c0 := EventId{"c", 0}
+
+	if !g.Before(a0, b1) {
+		t.Errorf("expected %s -> %s through the message", a0, b1)
+	}
+	if !g.Before(a1, b0) {
+		t.Errorf("expected send %s -> recv %s", a1, b0)
+	}
+	if g.Before(b0, a1) {
+		t.Errorf("recv %s can't happen before its send %s", b0, a1)
+	}
+	if !g.Concurrent(c0, a0) || g.Concurrent(a0, b0) || g.Concurrent(a0, a0) {
+		t.Errorf("wrong concurrency between events")
+	}
+	if got := g.ConcurrentWith(c0); len(got) != 4 {
+		t.Errorf("expected c:0 to be concurrent with every other event, got %v", got)
+	}
+
+	// a:0 -> b:0 is implied by a:0 -> a:1 -> b:0 so it never shows up.
+	want := []Edge{{a0, a1}, {a1, b0}, {b0, b1}}
+	got := g.Reduction()
+	if len(got) != len(want) {
+		t.Fatalf("expected reduction %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected edge %s, got %s", want[i], got[i])
+		}
+	}
+
+	// vector clocks characterize causality, there should be nothing to report.
+	if m := g.CheckClocks(); len(m) != 0 {
+		t.Errorf("vector clocks disagree with causality: %v", m)
+	}
+}
+
+func TestHappensBeforeGraphLamportMismatch(t *testing.T) {
+	g, err := graphCluster(NewLamportClock).HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// c:0 has timestamp 1 and b:1 has timestamp 4, lamport clocks
+	// say c:0 happened first even though they are concurrent.
+	found := false
+	for _, m := range g.CheckClocks() {
+		t.Log(m)
+		if m.A == (EventId{"b", 1}) && m.B == (EventId{"c", 0}) {
+			found = m.Causal == concurrent && m.Clock == happensAfter
+		}
+	}
+	if !found {
+		t.Errorf("expected lamport clocks to order concurrent events b:1 and c:0")
+	}
+}
+
+func TestHappensBeforeGraphBadTimestamps(t *testing.T) {
+	dlog := []eventLog{
+		{nodeId: "a", seq: 0, status: "send", timestamp: map[string]int{"a": 1, "b": 0}},
+		{nodeId: "b", seq: 0, status: "recv", from: EventId{"a", 0},
+			timestamp: map[string]int{"a": 0, "b": 1}}, // forgot to merge
+	}
+	g, err := newHBGraph(dlog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := g.CheckClocks()
+	if len(m) != 1 || m[0].Causal != happensBefore || m[0].Clock != concurrent {
+		t.Errorf("expected the missing merge to be reported, got %v", m)
+	}
+
+	dlog[1].from = EventId{"a", 7}
+	if _, err := newHBGraph(dlog); err == nil {
+		t.Errorf("expected an error for a recv without a send")
+	}
+}
@@ -0,0 +1,237 @@
+package clocks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Lamport's mutual exclusion algorithm, straight out of the paper that gave
+// us lamport clocks. Every node keeps a queue of requests ordered by their
+// (timestamp, id), the id breaks ties so every node sorts the queue the same
+// way and we get a total order.
+//
+// -> to ask for the critical section, a node puts its request in its own
+//    queue and sends it to everyone else.
+// -> on a request, a node puts it in its queue and replies.
+// -> a node enters when its own request is at the head of its queue and it
+//    has heard something newer than its request from every other node.
+// -> on leaving, a node takes its request off its queue and tells everyone
+//    to do the same.
+//
+// Channels have to be FIFO, otherwise a reply could overtake an older
+// request and the queue would lie. Every entry costs 3(N-1) messages.
+
+const lamportMutexMsg = "lamport-mutex "
+
+var (
+	errNotLamport    = errors.New("cluster does not run lamport clocks")
+	errAlreadyAsked  = errors.New("node already asked for the critical section")
+	errNotInCritical = errors.New("node is not in the critical section")
+)
+
+// mutexRequest is a request for the critical section.
+type mutexRequest struct {
+	ts int
+	id string
+}
+
+func (r mutexRequest) before(o mutexRequest) bool {
+	if r.ts != o.ts {
+		return r.ts < o.ts
+	}
+	return r.id < o.id
+}
+
+// LamportMutex runs Lamport's mutual exclusion algorithm on a cluster.
+type LamportMutex struct {
+	cl       *Cluster
+	queue    map[string][]mutexRequest // request queue of every node
+	latest   map[string]map[string]int // latest[i][j] newest timestamp i heard from j
+	pending  map[string]*mutexRequest  // request a node is waiting on
+	asked    map[string][]string       // nodes a waiting node sent its request to
+	holding  map[string]bool
+	onEnter  func(id string)
+	messages int
+}
+
+// NewLamportMutex starts the algorithm on a cluster running lamport clocks.
+func NewLamportMutex(cl *Cluster) (*LamportMutex, error) {
+	for _, no := range cl.nodes {
+		if _, ok := no.Clock.(*LamportClock); !ok {
+			return nil, errNotLamport
+		}
+	}
+	lm := &LamportMutex{
+		cl:      cl,
+		queue:   make(map[string][]mutexRequest),
+		latest:  make(map[string]map[string]int),
+		pending: make(map[string]*mutexRequest),
+		asked:   make(map[string][]string),
+		holding: make(map[string]bool),
+	}
+	for id := range cl.nodes {
+		lm.latest[id] = make(map[string]int)
+	}
+	cl.onReceive(lm.receive)
+	cl.onChange(lm.change)
+	return lm, nil
+}
+
+// Acquire asks for the critical section on behalf of a node.
+func (lm *LamportMutex) Acquire(id string) error {
+	if lm.cl.Get(id) == nil {
+		return fmt.Errorf("node id not in cluster: %s", id)
+	}
+	if lm.pending[id] != nil || lm.holding[id] {
+		return errAlreadyAsked
+	}
+	var asked []string
+	for _, other := range lm.cl.ids() {
+		if other != id {
+			asked = append(asked, other)
+		}
+	}
+	if err := lm.broadcast(id, "request"); err != nil {
+		return err
+	}
+	req := mutexRequest{ts: lm.cl.nodes[id].Get().(int), id: id}
+	lm.pending[id] = &req
+	lm.asked[id] = asked
+	lm.enqueue(id, req)
+	lm.tryEnter(id)
+	return nil
+}
+
+// Release leaves the critical section.
+func (lm *LamportMutex) Release(id string) error {
+	if lm.cl.Get(id) == nil {
+		return fmt.Errorf("node id not in cluster: %s", id)
+	}
+	if !lm.holding[id] {
+		return errNotInCritical
+	}
+	lm.cl.nodes[id].genLocalEvent(csExit, "left critical section")
+	lm.holding[id] = false
+	lm.dequeue(id, id)
+	return lm.broadcast(id, "release")
+}
+
+// Holding reports whether the node is in the critical section.
+func (lm *LamportMutex) Holding(id string) bool { return lm.holding[id] }
+
+// OnEnter sets a function to call whenever a node enters the critical section.
+func (lm *LamportMutex) OnEnter(fn func(id string)) { lm.onEnter = fn }
+
+// Messages returns the number of messages sent so far.
+func (lm *LamportMutex) Messages() int { return lm.messages }
+
+func (lm *LamportMutex) broadcast(id, kind string) error {
+	if err := lm.cl.Broadcast(id, lamportMutexMsg+kind); err != nil {
+		return err
+	}
+	lm.messages += len(lm.cl.nodes) - 1
+	return nil
+}
+
+// receive handles the messages of the algorithm delivered in the cluster.
+func (lm *LamportMutex) receive(m message) {
+	if !strings.HasPrefix(m.msg, lamportMutexMsg) {
+		return
+	}
+	ts := m.clock.(int)
+	if lm.latest[m.to] == nil {
+		// joined after the algorithm started.
+		lm.latest[m.to] = make(map[string]int)
+	}
+	if ts > lm.latest[m.to][m.from] {
+		lm.latest[m.to][m.from] = ts
+	}
+	switch strings.TrimPrefix(m.msg, lamportMutexMsg) {
+	case "request":
+		lm.enqueue(m.to, mutexRequest{ts: ts, id: m.from})
+		if lm.cl.Send(m.to, m.from, lamportMutexMsg+"reply") == nil {
+			lm.messages++
+		}
+	case "release":
+		lm.dequeue(m.to, m.from)
+	}
+	lm.tryEnter(m.to)
+}
+
+// change catches up a node that joined on the requests still open, it
+// never got them and would otherwise go in ahead of them. When a node
+// leaves, the ones that were waiting on it get another go.
+func (lm *LamportMutex) change(id string, joined bool) {
+	if joined {
+		lm.latest[id] = make(map[string]int)
+		for _, other := range lm.cl.ids() {
+			if req := lm.pending[other]; req != nil {
+				lm.enqueue(id, *req)
+			}
+			if lm.holding[other] {
+				for _, r := range lm.queue[other] {
+					if r.id == other {
+						lm.enqueue(id, r)
+					}
+				}
+			}
+		}
+		return
+	}
+	delete(lm.queue, id)
+	delete(lm.latest, id)
+	delete(lm.pending, id)
+	delete(lm.asked, id)
+	delete(lm.holding, id)
+	for _, other := range lm.cl.ids() {
+		lm.tryEnter(other)
+	}
+}
+
+func (lm *LamportMutex) enqueue(id string, req mutexRequest) {
+	q := append(lm.queue[id], req)
+	sort.Slice(q, func(i, j int) bool { return q[i].before(q[j]) })
+	lm.queue[id] = q
+}
+
+// dequeue takes the request of a node off the queue of id.
+func (lm *LamportMutex) dequeue(id, of string) {
+	q := lm.queue[id]
+	for i, req := range q {
+		if req.id == of {
+			lm.queue[id] = append(q[:i], q[i+1:]...)
+			return
+		}
+	}
+}
+
+// tryEnter lets the node in if its request is first in line and everyone
+// it asked has moved past it.
+func (lm *LamportMutex) tryEnter(id string) {
+	// a node that left won't release, its request goes.
+	q := lm.queue[id][:0]
+	for _, r := range lm.queue[id] {
+		if lm.cl.Get(r.id) != nil {
+			q = append(q, r)
+		}
+	}
+	lm.queue[id] = q
+	req := lm.pending[id]
+	if req == nil || len(lm.queue[id]) == 0 || lm.queue[id][0] != *req {
+		return
+	}
+	for _, other := range lm.asked[id] {
+		if lm.cl.Get(other) != nil && lm.latest[id][other] <= req.ts {
+			return
+		}
+	}
+	delete(lm.pending, id)
+	delete(lm.asked, id)
+	lm.holding[id] = true
+	lm.cl.nodes[id].genLocalEvent(csEnter, "entered critical section")
+	if lm.onEnter != nil {
+		lm.onEnter(id)
+	}
+}
@@ -0,0 +1,95 @@
+package clocks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLamportMutex(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b", "c")
+	s := NewScheduler(cl, 11)
+	lm, err := NewLamportMutex(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entered []string
+	lm.OnEnter(func(id string) { entered = append(entered, id) })
+	s.At(0, func() {
+		lm.Acquire("b")
+		lm.Acquire("a")
+	})
+	s.Run()
+
+	// a and b ask with the same timestamp, the id breaks the tie.
+	if len(entered) != 1 || entered[0] != "a" || !lm.Holding("a") {
+		t.Fatalf("expected only a in the critical section, got %v", entered)
+	}
+	if err := lm.Acquire(entered[0]); err == nil {
+		t.Errorf("expected holder to not ask again")
+	}
+	if err := lm.Release("c"); err == nil {
+		t.Errorf("expected c to not be able to release what it doesn't hold")
+	}
+
+	lm.Release(entered[0])
+	s.Run()
+	if len(entered) != 2 || entered[0] == entered[1] {
+		t.Fatalf("expected the other node to get in after the release, got %v", entered)
+	}
+	lm.Release(entered[1])
+	s.Run()
+
+	overlaps, err := CheckMutualExclusion(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlaps) != 0 {
+		t.Errorf("critical sections overlap: %v", overlaps)
+	}
+}
+
+func TestLamportMutexWorkload(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b", "c", "d", "e")
+	s := NewScheduler(cl, 99)
+	lm, err := NewLamportMutex(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := RunMutexWorkload(s, lm, 40, 20*time.Millisecond, 2*time.Second)
+	t.Logf("%+v", stats)
+	if stats.Entries != 40 {
+		t.Errorf("expected every request to get in, got %d", stats.Entries)
+	}
+	if stats.Messages != 40*3*4 {
+		t.Errorf("expected 3(N-1) messages per entry, got %d", stats.Messages)
+	}
+
+	overlaps, err := CheckMutualExclusion(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlaps) != 0 {
+		t.Errorf("critical sections overlap: %v", overlaps)
+	}
+}
+
+func TestCheckMutualExclusionCatchesOverlaps(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b")
+	cl.nodes["a"].genLocalEvent(csEnter, "entered critical section")
+	cl.nodes["b"].genLocalEvent(csEnter, "entered critical section")
+	cl.nodes["a"].genLocalEvent(csExit, "left critical section")
+
+	overlaps, err := CheckMutualExclusion(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlaps) != 1 || overlaps[0] != (Overlap{EventId{"a", 0}, EventId{"b", 0}}) {
+		t.Errorf("expected a and b to overlap, got %v", overlaps)
+	}
+
+	if _, err := NewLamportMutex(NewCluster(NewVectorClock, "a")); err == nil {
+		t.Errorf("expected lamport mutex to need lamport clocks")
+	}
+}
@@ -0,0 +1,271 @@
+// Package lattice enumerates the consistent global states of a recorded run
+// and detects global predicates on them.
+//
+// A run of a distributed system doesn't have one sequence of global states
+// that everyone agrees on, it has a whole lattice of them. Every consistent
+// cut through the logs of the nodes is a global state the system could have
+// been in, and an observer can't tell which path through the lattice really
+// happened. So instead of asking "was the predicate ever true" we ask two
+// questions (Cooper and Marzullo):
+//
+// -> possibly: is there some consistent state where the predicate holds.
+// -> definitely: does every path from the initial to the final state go
+//
+//	through a state where the predicate holds.
+//
+// Vector timestamps are all we need to know whether a cut is consistent,
+// so the package works on recorded vector timestamps and nothing else.
+package lattice
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Event is a single recorded event of a node. Every event must increment
+// the node's own entry in its vector clock by one, so Clock[node] is the
+// position of the event in the node's history counting from one.
+type Event struct {
+	Node  string
+	Clock map[string]int
+	Kind  string // send, recv, internal ...
+	Label string // whatever the recorder wants predicates to see
+}
+
+// Trace holds the events of every node in the order they happened.
+type Trace map[string][]Event
+
+// nodes returns the node ids of the trace in sorted order.
+func (t Trace) nodes() []string {
+	ids := make([]string, 0, len(t))
+	for id := range t {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// State is a consistent global state of a trace, every node has executed
+// some prefix of its events.
+type State struct {
+	trace Trace
+	nodes []string
+	cut   []int
+}
+
+// Index returns the number of events of the node that are part of the state.
+func (s State) Index(node string) int {
+	for i, id := range s.nodes {
+		if id == node {
+			return s.cut[i]
+		}
+	}
+	return 0
+}
+
+// Events returns the events of the node that are part of the state.
+func (s State) Events(node string) []Event {
+	return s.trace[node][:s.Index(node)]
+}
+
+// Last returns the last event the node executed in the state, false if
+// the node is still in its initial state.
+func (s State) Last(node string) (Event, bool) {
+	k := s.Index(node)
+	if k == 0 {
+		return Event{}, false
+	}
+	return s.trace[node][k-1], true
+}
+
+// Cut returns the state as the number of events per node.
+func (s State) Cut() map[string]int {
+	cut := make(map[string]int, len(s.nodes))
+	for i, id := range s.nodes {
+		cut[id] = s.cut[i]
+	}
+	return cut
+}
+
+func (s State) String() string {
+	parts := make([]string, len(s.nodes))
+	for i, id := range s.nodes {
+		parts[i] = fmt.Sprintf("%s:%d", id, s.cut[i])
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+func (s State) key() string {
+	return fmt.Sprint(s.cut)
+}
+
+// Predicate is a property of a global state.
+type Predicate func(State) bool
+
+// LocalPredicate is a property of a single node, given the events it
+// has executed so far.
+type LocalPredicate func(events []Event) bool
+
+// initial returns the state before any event happened.
+func initial(t Trace) State {
+	nodes := t.nodes()
+	return State{trace: t, nodes: nodes, cut: make([]int, len(nodes))}
+}
+
+// final reports whether every event of the trace is part of the state.
+func (s State) final() bool {
+	for i, id := range s.nodes {
+		if s.cut[i] < len(s.trace[id]) {
+			return false
+		}
+	}
+	return true
+}
+
+// successors returns the consistent states reachable by executing one
+// more event. Only the new event has to be checked, the rest of the cut
+// was already consistent and adding events only loosens the constraints
+// on the others.
+func (s State) successors() []State {
+	var next []State
+	for i, id := range s.nodes {
+		if s.cut[i] == len(s.trace[id]) {
+			continue
+		}
+		e := s.trace[id][s.cut[i]]
+		consistent := true
+		for j, other := range s.nodes {
+			if j != i && e.Clock[other] > s.cut[j] {
+				// e depends on an event of other that isn't in the cut.
+				consistent = false
+				break
+			}
+		}
+		if !consistent {
+			continue
+		}
+		cut := append([]int(nil), s.cut...)
+		cut[i]++
+		next = append(next, State{trace: s.trace, nodes: s.nodes, cut: cut})
+	}
+	return next
+}
+
+// walk visits the lattice level by level, a level is every state with
+// the same number of events. States are only expanded if expand returns
+// true for them, which is where the pruning happens. visit returning
+// false stops the walk.
+func walk(t Trace, expand func(State) bool, visit func(State) bool) {
+	level := []State{initial(t)}
+	for len(level) > 0 {
+		seen := make(map[string]bool)
+		var next []State
+		for _, s := range level {
+			if !visit(s) {
+				return
+			}
+			if !expand(s) {
+				continue
+			}
+			for _, n := range s.successors() {
+				if !seen[n.key()] {
+					seen[n.key()] = true
+					next = append(next, n)
+				}
+			}
+		}
+		level = next
+	}
+}
+
+// Enumerate calls fn with every consistent global state of the trace, level
+// by level from the initial state. Returning false from fn stops it.
+func Enumerate(t Trace, fn func(State) bool) {
+	walk(t, func(State) bool { return true }, fn)
+}
+
+// Possibly reports whether the predicate holds in some consistent global
+// state, and returns the first such state found. The walk stops as soon
+// as one is found.
+func Possibly(t Trace, p Predicate) (State, bool) {
+	var found State
+	ok := false
+	Enumerate(t, func(s State) bool {
+		if p(s) {
+			found, ok = s, true
+			return false
+		}
+		return true
+	})
+	return found, ok
+}
+
+// Definitely reports whether every path through the lattice passes through
+// a state where the predicate holds. States where it holds are not expanded,
+// if the final state can still be reached then some path avoided the
+// predicate the whole way.
+func Definitely(t Trace, p Predicate) bool {
+	definitely := true
+	walk(t, func(s State) bool { return !p(s) }, func(s State) bool {
+		if s.final() && !p(s) {
+			definitely = false
+			return false
+		}
+		return true
+	})
+	return definitely
+}
+
+// PossiblyAll detects a conjunction of local predicates without walking the
+// lattice (Garg and Waldecker). Every node starts at its earliest local state
+// where its predicate holds, while two of the candidates can't be in the same
+// consistent state the one that is too early moves on to its next candidate.
+// Its polynomial in the number of events instead of exponential in the number of
+// nodes. Nodes without a predicate are free to be in any state.
+func PossiblyAll(t Trace, locals map[string]LocalPredicate) (State, bool) {
+	s := initial(t)
+
+	// next moves node i to its first local state at or after k where its
+	// predicate holds.
+	next := func(i, k int) bool {
+		id := s.nodes[i]
+		p, ok := locals[id]
+		for ; k <= len(t[id]); k++ {
+			if !ok || p(t[id][:k]) {
+				s.cut[i] = k
+				return true
+			}
+		}
+		return false
+	}
+	for i := range s.nodes {
+		if !next(i, 0) {
+			return State{}, false
+		}
+	}
+
+	for {
+		moved := false
+		for j, idj := range s.nodes {
+			if s.cut[j] == 0 {
+				continue
+			}
+			e := t[idj][s.cut[j]-1]
+			for i, idi := range s.nodes {
+				if i == j || e.Clock[idi] <= s.cut[i] {
+					continue
+				}
+				// the state of i is left behind by an event j already
+				// depends on, i has to move forward.
+				if !next(i, e.Clock[idi]) {
+					return State{}, false
+				}
+				moved = true
+			}
+		}
+		if !moved {
+			return s, true
+		}
+	}
+}
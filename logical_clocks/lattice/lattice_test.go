@@ -0,0 +1,134 @@
+package lattice
+
+import (
+	"testing"
+)
+
+func ev(node, label string, clock map[string]int) Event {
+	return Event{Node: node, Kind: "internal", Label: label, Clock: clock}
+}
+
+// inCS tells if the last enter/exit event of a node was an enter.
+func inCS(events []Event) bool {
+	for i := len(events) - 1; i >= 0; i-- {
+		switch events[i].Label {
+		case "enter":
+			return true
+		case "exit":
+			return false
+		}
+	}
+	return false
+}
+
+func bothInCS(s State) bool {
+	return inCS(s.Events("a")) && inCS(s.Events("b"))
+}
+
+// a and b enter and leave the critical section without talking to each other.
+func concurrentTrace() Trace {
+	return Trace{
+		"a": {
+			ev("a", "enter", map[string]int{"a": 1}),
+			ev("a", "exit", map[string]int{"a": 2}),
+		},
+		"b": {
+			ev("b", "enter", map[string]int{"b": 1}),
+			ev("b", "exit", map[string]int{"b": 2}),
+		},
+	}
+}
+
+// a leaves the critical section and tells b before b enters.
+func orderedTrace() Trace {
+	return Trace{
+		"a": {
+			ev("a", "enter", map[string]int{"a": 1}),
+			ev("a", "exit", map[string]int{"a": 2}),
+			{Node: "a", Kind: "send", Clock: map[string]int{"a": 3}},
+		},
+		"b": {
+			{Node: "b", Kind: "recv", Clock: map[string]int{"a": 3, "b": 1}},
+			ev("b", "enter", map[string]int{"a": 3, "b": 2}),
+			ev("b", "exit", map[string]int{"a": 3, "b": 3}),
+		},
+	}
+}
+
+func TestEnumerate(t *testing.T) {
+	tt := []struct {
+		name  string
+		trace Trace
+		want  int
+	}{
+		{"independent nodes make a grid", concurrentTrace(), 9},
+		{"messages cut the lattice down", orderedTrace(), 7},
+		{"empty trace has only the initial state", Trace{"a": nil}, 1},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			n := 0
+			Enumerate(tc.trace, func(s State) bool {
+				n++
+				return true
+			})
+			if n != tc.want {
+				t.Errorf("expected %d states, got %d", tc.want, n)
+			}
+		})
+	}
+}
+
+func TestPossibly(t *testing.T) {
+	s, ok := Possibly(concurrentTrace(), bothInCS)
+	if !ok {
+		t.Fatal("expected a and b to possibly be in the critical section together")
+	}
+	if s.Index("a") != 1 || s.Index("b") != 1 {
+		t.Errorf("expected state [a:1 b:1], got %s", s)
+	}
+
+	if s, ok := Possibly(orderedTrace(), bothInCS); ok {
+		t.Errorf("a tells b it left before b enters, got both in at %s", s)
+	}
+}
+
+func TestDefinitely(t *testing.T) {
+	if Definitely(concurrentTrace(), bothInCS) {
+		t.Error("a can leave before b enters, both in is not definite")
+	}
+
+	// whatever the order, some node executes the first event alone.
+	one := func(s State) bool { return s.Index("a")+s.Index("b") == 1 }
+	if !Definitely(concurrentTrace(), one) {
+		t.Error("every path goes through a state with exactly one event")
+	}
+
+	aIn := func(s State) bool { return inCS(s.Events("a")) }
+	if !Definitely(orderedTrace(), aIn) {
+		t.Error("every path goes through a being in the critical section")
+	}
+}
+
+func TestPossiblyAll(t *testing.T) {
+	locals := map[string]LocalPredicate{"a": inCS, "b": inCS}
+
+	s, ok := PossiblyAll(concurrentTrace(), locals)
+	if !ok || s.Index("a") != 1 || s.Index("b") != 1 {
+		t.Errorf("expected both in at [a:1 b:1], got %s %v", s, ok)
+	}
+	if s, ok := PossiblyAll(orderedTrace(), locals); ok {
+		t.Errorf("expected both in to be impossible, got %s", s)
+	}
+
+	// b is only in after hearing from a, a has to move past its send.
+	trace := orderedTrace()
+	trace["a"] = append(trace["a"], ev("a", "enter", map[string]int{"a": 4}))
+	s, ok = PossiblyAll(trace, locals)
+	if !ok || s.Index("a") != 4 || s.Index("b") != 2 {
+		t.Errorf("expected both in at [a:4 b:2], got %s %v", s, ok)
+	}
+	if _, ok := Possibly(trace, bothInCS); !ok {
+		t.Error("walking the lattice should agree with PossiblyAll")
+	}
+}
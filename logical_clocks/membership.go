@@ -0,0 +1,148 @@
+package clocks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Nodes come and go while the cluster is running. A node that joins has to
+// catch up with the clocks of the cluster, otherwise its first events would
+// look concurrent with everything that happened before it showed up. Every
+// live member sends it a welcome message stamped with its clock, so the
+// join is ordered after everything the members have seen and the logs say
+// why. Vector clocks of the members gain an entry for the new node.
+//
+// A node that leaves keeps its entry in the vectors of the others, the
+// counts in it are still needed to compare timestamps taken before it left.
+// Vectors of different sizes compare with missing entries counting as zero,
+// which is what they would have been if the entry was there all along.
+
+// statuses of the events nodes record when they join and leave.
+const (
+	joinEvent  = "join"
+	leaveEvent = "leave"
+)
+
+var (
+	errNodeExists = errors.New("node id already used in cluster")
+	errNodeLeft   = errors.New("node left the cluster, ids can't be reused")
+)
+
+// Join adds a node to the running cluster.
+func (cl *Cluster) Join(id string) error {
+	if cl.Get(id) != nil {
+		return errNodeExists
+	}
+	if _, ok := cl.gone[id]; ok {
+		return errNodeLeft
+	}
+	if cl.snapshotRunning() {
+		return errSnapshotRunning
+	}
+	cl.tick()
+
+	members := cl.ids()
+	no := NewNode(id, cl.clock())
+	if vc, ok := no.Clock.(*VectorClock); ok {
+		vc.registerId(id)
+		for _, other := range members {
+			cl.nodes[other].Clock.(*VectorClock).addMember(id)
+		}
+	}
+	if cl.sched != nil {
+		cl.sched.attachHardwareClock(no)
+	}
+	cl.nodes[id] = no
+	no.genLocalEvent(joinEvent, "joined the cluster")
+
+	for _, other := range members {
+		if !cl.nodes[other].running() || cl.partition[other] != cl.partition[id] {
+			continue
+		}
+		no.deliver(cl.nodes[other].stampSend("welcome "+id, id))
+	}
+	// the welcomes are the cluster's business, algorithms hear from here on.
+	no.onDeliver = cl.handle
+	cl.changed(id, true)
+	return nil
+}
+
+// Leave takes a node out of the running cluster. Messages on their way to
+// or from it are lost.
+func (cl *Cluster) Leave(id string) error {
+	no := cl.Get(id)
+	if no == nil {
+		return fmt.Errorf("node id not in cluster: %s", id)
+	}
+	if cl.snapshotRunning() {
+		return errSnapshotRunning
+	}
+	cl.tick()
+	no.genLocalEvent(leaveEvent, "left the cluster")
+
+	for _, other := range cl.ids() {
+		for _, m := range cl.links[other][id].drop() {
+			cl.logFault(other, Drop, fmt.Sprintf("dropped message to %s (node left): %s", id, m.msg))
+		}
+		for _, m := range cl.links[id][other].drop() {
+			cl.logFault(other, Drop, fmt.Sprintf("dropped message from %s (node left): %s", id, m.msg))
+		}
+	}
+	delete(cl.links, id)
+	for _, links := range cl.links {
+		delete(links, id)
+	}
+	delete(cl.partition, id)
+	delete(cl.nodes, id)
+	cl.gone[id] = no
+	cl.changed(id, false)
+	return nil
+}
+
+// onChange registers an algorithm to hear about nodes joining and leaving.
+func (cl *Cluster) onChange(w func(id string, joined bool)) {
+	cl.watchers = append(cl.watchers, w)
+}
+
+func (cl *Cluster) changed(id string, joined bool) {
+	for _, w := range cl.watchers {
+		w(id, joined)
+	}
+}
+
+// up reports whether a node is in the cluster and running. Algorithms
+// that hold on to ids use it, a node that left is down for good.
+func (cl *Cluster) up(id string) bool {
+	no := cl.Get(id)
+	return no != nil && no.running()
+}
+
+// drop empties the link and returns what was on it.
+func (l *link) drop() []message {
+	if l == nil {
+		return nil
+	}
+	q := l.q
+	l.q = nil
+	return q
+}
+
+// everyone returns the ids of the nodes that are or ever were in the
+// cluster in sorted order, their logs make up the history of the cluster.
+func (cl *Cluster) everyone() []string {
+	ids := cl.ids()
+	for id := range cl.gone {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// logOf returns the log of a node, even if it left.
+func (cl *Cluster) logOf(id string) []eventLog {
+	if no, ok := cl.nodes[id]; ok {
+		return no.log
+	}
+	return cl.gone[id].log
+}
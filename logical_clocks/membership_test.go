@@ -0,0 +1,282 @@
+package clocks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVectorClockDifferentSizes(t *testing.T) {
+	a := &VectorClock{val: map[string]int{"a": 1}}
+	b := &VectorClock{val: map[string]int{"a": 1, "b": 2}}
+	zero := &VectorClock{val: map[string]int{"a": 1, "b": 0}}
+
+	if !a.HappensBefore(b) || b.HappensBefore(a) {
+		t.Errorf("expected %v -> %v", a, b)
+	}
+	if a.HappensBefore(zero) || zero.HappensBefore(a) {
+		t.Errorf("expected %v and %v to be the same time", a, zero)
+	}
+	if !lessThan(a.val, b.val) || lessThan(b.val, a.val) {
+		t.Error("lessThan should count missing entries as zero")
+	}
+}
+
+func TestJoinCatchesUp(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b")
+	cl.Send("a", "b", "hello")
+	cl.Drain()
+	cl.Internal("b")
+
+	if err := cl.Join("c"); err != nil {
+		t.Fatal(err)
+	}
+	c := cl.Get("c").Get().(map[string]int)
+	if c["a"] < 2 || c["b"] < 3 {
+		t.Errorf("expected c to have caught up with a and b, got %v", c)
+	}
+	for _, id := range []string{"a", "b"} {
+		if _, ok := cl.Get(id).Get().(map[string]int)["c"]; !ok {
+			t.Errorf("expected %s to have an entry for c", id)
+		}
+	}
+
+	cl.Send("c", "a", "hi, i'm new")
+	cl.Drain()
+	cl.appendLogs()
+	g, err := cl.HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mm := g.CheckClocks(); len(mm) != 0 {
+		t.Errorf("clocks disagree with causality after a join: %v", mm)
+	}
+
+	if err := cl.Join("a"); err != errNodeExists {
+		t.Errorf("expected %v, got %v", errNodeExists, err)
+	}
+}
+
+func TestLeaveDropsMessages(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b", "c")
+	cl.Send("a", "b", "one")
+	cl.Send("b", "c", "two")
+	cl.Send("c", "a", "three")
+
+	if err := cl.Leave("b"); err != nil {
+		t.Fatal(err)
+	}
+	if cl.Get("b") != nil {
+		t.Fatal("b is still in the cluster")
+	}
+	cl.Drain()
+	cl.appendLogs()
+	if n := countFaults(cl, Drop); n != 2 {
+		t.Errorf("expected the 2 messages to and from b to be lost, got %d", n)
+	}
+	if n := countFaults(cl, leaveEvent); n != 1 {
+		t.Errorf("expected b's leave event in the log, got %d", n)
+	}
+	if cl.Send("a", "b", "anyone there?") == nil {
+		t.Error("expected an error sending to a node that left")
+	}
+	if err := cl.Join("b"); err != errNodeLeft {
+		t.Errorf("expected %v, got %v", errNodeLeft, err)
+	}
+
+	// the history of b is still there and still makes sense.
+	g, err := cl.HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mm := g.CheckClocks(); len(mm) != 0 {
+		t.Errorf("clocks disagree with causality after a leave: %v", mm)
+	}
+}
+
+func TestChurnWithScheduler(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b", "c")
+	s := NewScheduler(cl, 37)
+	s.RandomWorkload(60, time.Second)
+	s.At(300*time.Millisecond, func() { cl.Join("d") })
+	s.At(500*time.Millisecond, func() { cl.Leave("a") })
+	s.At(600*time.Millisecond, func() { cl.Send("d", "c", "from the new node") })
+	s.Run()
+
+	cl.appendLogs()
+	g, err := cl.HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mm := g.CheckClocks(); len(mm) != 0 {
+		t.Errorf("seed %d: clocks disagree with causality: %v", s.Seed(), mm)
+	}
+	if _, err := cl.Trace(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMembershipAfterSnapshot(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b")
+	if err := cl.StartSnapshot("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Join("c"); err != errSnapshotRunning {
+		t.Errorf("expected %v while markers are on their way, got %v", errSnapshotRunning, err)
+	}
+	cl.Drain()
+	if _, ok := cl.Snapshot(); !ok {
+		t.Fatal("expected the snapshot to be done")
+	}
+	if err := cl.Join("c"); err != nil {
+		t.Errorf("expected join after the snapshot to work, got %v", err)
+	}
+	if err := cl.Leave("b"); err != nil {
+		t.Errorf("expected leave after the snapshot to work, got %v", err)
+	}
+}
+
+func TestElectionWithChurn(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b", "c")
+	s := NewScheduler(cl, 7)
+	cr, err := NewChangRoberts(cl, s, "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBully(cl, s)
+
+	// c leaves, the ring goes around it and nothing is left to bully.
+	if err := cl.Leave("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cr.Start("c"); err != errSystemDown {
+		t.Errorf("expected a node that left to be down, got %v", err)
+	}
+	s.At(0, func() {
+		cr.Start("a")
+		b.Start("a")
+	})
+	s.Run()
+	checkLeader(t, cl, cr.Leader, "b")
+	checkLeader(t, cl, b.Leader, "b")
+
+	// d joins and bullies its way to the top.
+	if err := cl.Join("d"); err != nil {
+		t.Fatal(err)
+	}
+	s.At(s.Now(), func() { b.Start("d") })
+	s.Run()
+	checkLeader(t, cl, b.Leader, "d")
+}
+
+func TestLamportMutexWithChurn(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b")
+	s := NewScheduler(cl, 11)
+	lm, err := NewLamportMutex(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entered []string
+	lm.OnEnter(func(id string) { entered = append(entered, id) })
+
+	if err := cl.Join("c"); err != nil {
+		t.Fatal(err)
+	}
+	s.At(0, func() { lm.Acquire("c") })
+	s.Run()
+	if !lm.Holding("c") {
+		t.Fatalf("expected c to get in after joining, got %v", entered)
+	}
+
+	// b asks and leaves, a doesn't wait on it forever.
+	s.At(s.Now(), func() {
+		lm.Acquire("b")
+		lm.Acquire("a")
+	})
+	s.Run()
+	cl.Leave("b")
+	lm.Release("c")
+	s.Run()
+	if !lm.Holding("a") {
+		t.Fatalf("expected a to get in after b left, got %v", entered)
+	}
+	lm.Release("a")
+	s.Run()
+
+	overlaps, err := CheckMutualExclusion(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlaps) != 0 {
+		t.Errorf("critical sections overlap: %v", overlaps)
+	}
+}
+
+func TestLamportMutexJoinMidRequest(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b")
+	lm, err := NewLamportMutex(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.Acquire("a"); err != nil {
+		t.Fatal(err)
+	}
+	// c never got a's request and owes it nothing.
+	if err := cl.Join("c"); err != nil {
+		t.Fatal(err)
+	}
+	cl.Drain()
+	if !lm.Holding("a") {
+		t.Fatal("expected a to get in with c joining while it asked")
+	}
+
+	// c still knows a is in there.
+	if err := lm.Acquire("c"); err != nil {
+		t.Fatal(err)
+	}
+	cl.Drain()
+	if lm.Holding("c") {
+		t.Fatal("expected c to wait for a to leave")
+	}
+	lm.Release("a")
+	cl.Drain()
+	if !lm.Holding("c") {
+		t.Fatal("expected c to get in after a left")
+	}
+}
+
+func TestRicartAgrawalaWithChurn(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b", "c")
+	ra, err := NewRicartAgrawala(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b holds and defers its reply to a, then leaves.
+	ra.Acquire("b")
+	cl.Drain()
+	ra.Acquire("a")
+	cl.Drain()
+	if !ra.Holding("b") || ra.Holding("a") {
+		t.Fatal("expected b in and a waiting")
+	}
+	if err := cl.Leave("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ra.Release("b"); err == nil {
+		t.Error("expected a node that left to not release")
+	}
+	if !ra.Holding("a") {
+		t.Fatal("expected a to get in once b left")
+	}
+
+	// d joins after c asked and owes it no reply.
+	ra.Acquire("c")
+	if err := cl.Join("d"); err != nil {
+		t.Fatal(err)
+	}
+	ra.Release("a")
+	cl.Drain()
+	if !ra.Holding("c") {
+		t.Fatal("expected c to get in without a reply from d")
+	}
+}
@@ -0,0 +1,145 @@
+package clocks
+
+import (
+	"time"
+)
+
+// Mutual exclusion is the first thing you want once you have clocks, only
+// one node at a time gets to be in the critical section and there is no
+// shared memory to put a lock in. The algorithms here all work by passing
+// timestamped messages around the cluster. They share the Mutex interface
+// so the same workload can be thrown at each of them and compared.
+
+// statuses of the events nodes record when they enter and leave the
+// critical section.
+const (
+	csEnter = "cs-enter"
+	csExit  = "cs-exit"
+)
+
+// Mutex is a distributed mutual exclusion algorithm running on a cluster.
+type Mutex interface {
+	Acquire(id string) error    // asks for the critical section, it is entered later
+	Release(id string) error    // leaves the critical section
+	Holding(id string) bool     // is the node in the critical section
+	OnEnter(fn func(id string)) // fn is called when a node enters
+	Messages() int              // number of messages the algorithm sent
+}
+
+// Overlap is two critical sections on different nodes that are not ordered
+// by happens-before, so nothing stopped them from running at the same time.
+type Overlap struct {
+	A, B EventId // the events where the nodes entered
+}
+
+// section is one stay of a node in the critical section.
+type section struct {
+	enter, exit EventId
+	open        bool // never left
+}
+
+// CheckMutualExclusion goes through the logs of the cluster and checks that
+// no two nodes were ever in the critical section at once. Two sections are
+// fine if one of them was left before the other was entered, in the
+// happens-before sense, anything else is reported.
+func CheckMutualExclusion(cl *Cluster) ([]Overlap, error) {
+	g, err := cl.HappensBeforeGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	var sections []section
+	open := make(map[string]int) // node -> index of its open section
+	for _, e := range cl.dlog {
+		switch e.status {
+		case csEnter:
+			open[e.nodeId] = len(sections)
+			sections = append(sections, section{enter: e.id(), open: true})
+		case csExit:
+			if i, ok := open[e.nodeId]; ok {
+				sections[i].exit, sections[i].open = e.id(), false
+				delete(open, e.nodeId)
+			}
+		}
+	}
+
+	// before tells if section a was left before b was entered.
+	before := func(a, b section) bool {
+		return !a.open && g.Before(a.exit, b.enter)
+	}
+	var overlaps []Overlap
+	for i := range sections {
+		for j := i + 1; j < len(sections); j++ {
+			a, b := sections[i], sections[j]
+			if a.enter.Node == b.enter.Node {
+				continue
+			}
+			if !before(a, b) && !before(b, a) {
+				overlaps = append(overlaps, Overlap{a.enter, b.enter})
+			}
+		}
+	}
+	return overlaps, nil
+}
+
+// MutexStats sums up how a mutual exclusion algorithm did on a workload.
+type MutexStats struct {
+	Entries  int
+	Messages int
+	MeanWait time.Duration // from asking for the critical section to entering it
+	MaxWait  time.Duration
+}
+
+// RunMutexWorkload throws requests for the critical section at random nodes
+// at random times in the window, every node holds the section for hold once
+// it gets in. A node that is already waiting or holding queues the request
+// up and asks again after it leaves. It runs the scheduler to the end.
+func RunMutexWorkload(s *Scheduler, m Mutex, requests int, hold, window time.Duration) MutexStats {
+	ids := s.cl.ids()
+	asked := make(map[string]time.Duration)
+	backlog := make(map[string]int)
+	start := m.Messages()
+
+	var stats MutexStats
+	var total time.Duration
+
+	acquire := func(id string) {
+		if m.Acquire(id) == nil {
+			asked[id] = s.Now()
+		}
+	}
+	m.OnEnter(func(id string) {
+		wait := s.Now() - asked[id]
+		stats.Entries++
+		total += wait
+		if wait > stats.MaxWait {
+			stats.MaxWait = wait
+		}
+		delete(asked, id)
+		s.After(hold, func() {
+			m.Release(id)
+			if backlog[id] > 0 {
+				backlog[id]--
+				acquire(id)
+			}
+		})
+	})
+
+	for i := 0; i < requests; i++ {
+		id := ids[s.rnd.Intn(len(ids))]
+		s.At(s.Now()+time.Duration(s.rnd.Int63n(int64(window))), func() {
+			if _, waiting := asked[id]; waiting || m.Holding(id) {
+				backlog[id]++
+				return
+			}
+			acquire(id)
+		})
+	}
+	s.Run()
+
+	stats.Messages = m.Messages() - start
+	if stats.Entries > 0 {
+		stats.MeanWait = total / time.Duration(stats.Entries)
+	}
+	return stats
+}
@@ -1,10 +1,10 @@
 package clocks
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"io"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -12,10 +12,16 @@ import (
 type Node struct {
 	Clock
 	id     string
-	status bool          // false means system is down
-	buf    *bytes.Buffer // underlying buffer
-	c      chan int
+	status bool     // false means system is down
+	inbox  *mailbox // messages that arrived but are not processed yet
 	log    []eventLog
+	hw     *hardwareClock // physical clock, only when a scheduler runs the cluster
+
+	onDeliver func(m message) // called with every message the node delivers
+
+	mu   sync.Mutex    // guards the clock, the log and the status
+	stop chan struct{} // stops the receive loop
+	done chan struct{} // closed when the receive loop is gone
 }
 
 func NewNode(id string, cl Clock) *Node {
@@ -23,8 +29,7 @@ func NewNode(id string, cl Clock) *Node {
 		Clock:  cl,
 		id:     id,
 		status: true,
-		c:      make(chan int),
-		buf:    bytes.NewBuffer(nil),
+		inbox:  newMailbox(),
 	}
 }
 
@@ -44,6 +49,30 @@ type eventLog struct {
 	msg       string
 	status    string
 	timestamp interface{}
+	seq       int           // position of the event in the node's log
+	fault     int           // for faults, which fault of the node it is from 1
+	from      EventId       // for recv events, the send event that carried the message
+	phys      time.Duration // reading of the node's hardware clock, if timed
+	timed     bool
+}
+
+// EventId identifies an event by the node that generated it and
+// its position in that node's log.
+type EventId struct {
+	Node string
+	Seq  int
+}
+
+func (e EventId) String() string { return fmt.Sprintf("%s:%d", e.Node, e.Seq) }
+
+// id returns the identity of the event in the cluster. faults are numbered
+// apart from the events of the node with negative seqs, a fault has the seq
+// of the event that comes after it and would take its id otherwise.
+func (e eventLog) id() EventId {
+	if e.fault > 0 {
+		return EventId{Node: e.nodeId, Seq: -e.fault}
+	}
+	return EventId{Node: e.nodeId, Seq: e.seq}
 }
 
 var (
@@ -52,26 +81,58 @@ var (
 )
 
 // simulates a system fault
-func (no *Node) changeStatus(b bool) { no.status = b }
+func (no *Node) changeStatus(b bool) {
+	no.mu.Lock()
+	no.status = b
+	no.mu.Unlock()
+}
+
+// running reports whether the node is up.
+func (no *Node) running() bool {
+	no.mu.Lock()
+	defer no.mu.Unlock()
+	return no.status
+}
 
 // increment the clock to simulate some kind of event generation and record in log.
 func (no *Node) genInternalEvent() {
+	no.mu.Lock()
+	defer no.mu.Unlock()
 	no.Increment()
 	no.genEvent(
 		fmt.Sprintf("[nodeId -> %s] [msg -> process related event] [timestamp -> %v] [event_type -> internal]",
 			no.id, no.Get()), "internal")
 }
 
+// genLocalEvent is an internal event with a status of its own, algorithms
+// running on the cluster use it to mark things like entering a critical
+// section in the log.
+func (no *Node) genLocalEvent(status, msg string) {
+	no.mu.Lock()
+	defer no.mu.Unlock()
+	no.Increment()
+	no.genEvent(
+		fmt.Sprintf("[nodeId -> %s] [msg -> %s] [timestamp -> %v] [event_type -> %s]",
+			no.id, msg, no.Get(), status), status)
+}
+
 func (no *Node) genEvent(msg, stat string) { no.addEventLog(msg, stat) }
 
 // add new event log to the nodes log of events.
+// the timestamp is copied so later clock updates don't rewrite history,
+// vector clocks hand out their underlying map from Get.
 func (no *Node) addEventLog(msg, status string) {
 	no.log = append(no.log, eventLog{
 		nodeId:    no.id,
 		msg:       msg,
 		status:    status,
-		timestamp: no.Get(),
+		timestamp: copyTimestamp(no.Get()),
+		seq:       len(no.log),
 	})
+	if no.hw != nil {
+		e := &no.log[len(no.log)-1]
+		e.phys, e.timed = no.hw.read(), true
+	}
 }
 
 // sorts the logs of each nodes in a cluster
@@ -99,66 +160,191 @@ func sortLamportLog(dlog []eventLog) {
 	}
 }
 
-// read data from node
-func (no *Node) Read(p []byte) (n int, err error) {
-	n, err = no.buf.Read(p)
-	if err != nil {
-		return n, err
-	}
-	no.buf.Reset()
-	return
+// vector clocks only give a partial order, concurrent events have no order
+// between them. sorting them means picking a linear extension of the
+// happens-before order, any order that never puts an event before something
+// that happened before it. The sum of a vector grows on every event and
+// anything that happens before an event has a strictly smaller sum, so
+// sorting by sum is such an extension. Ties are broken by node id and
+// position in the node's log so the result is the same on every run.
+func sortVectorLog(dlog []eventLog) {
+	sort.SliceStable(dlog, func(i, j int) bool {
+		si, sj := vectorSum(dlog[i].timestamp), vectorSum(dlog[j].timestamp)
+		if si != sj {
+			return si < sj
+		}
+		if dlog[i].nodeId != dlog[j].nodeId {
+			return dlog[i].nodeId < dlog[j].nodeId
+		}
+		return dlog[i].seq < dlog[j].seq
+	})
 }
 
-// write data to node
-func (no *Node) Write(p []byte) (n int, err error) {
-	if !no.status {
-		return 0, errSystemDown
+// layerVectorLog groups vector timestamped logs into layers of concurrent
+// events. An event goes in the layer after the deepest event that happens
+// before it, so all events in a layer are concurrent with each other and
+// every event only depends on events from earlier layers.
+func layerVectorLog(dlog []eventLog) [][]eventLog {
+	sorted := append([]eventLog(nil), dlog...)
+	sortVectorLog(sorted)
+
+	depth := make([]int, len(sorted))
+	var layers [][]eventLog
+	for i, e := range sorted {
+		for j := 0; j < i; j++ {
+			if vectorLess(sorted[j].timestamp, e.timestamp) && depth[j]+1 > depth[i] {
+				depth[i] = depth[j] + 1
+			}
+		}
+		if depth[i] == len(layers) {
+			layers = append(layers, nil)
+		}
+		layers[depth[i]] = append(layers[depth[i]], e)
 	}
-	n, err = no.buf.Write(p)
-	if err != nil {
-		return n, err
+	return layers
+}
+
+func vectorSum(ts interface{}) int {
+	sum := 0
+	for _, v := range ts.(map[string]int) {
+		sum += v
 	}
-	return
+	return sum
 }
 
-func (no *Node) send(msg string, r *Node) {
-	go r.recv(no) // launch go routine to listen for connections
+// message is what travels over a link in the cluster. It carries the
+// timestamp of the sender at the time of the send, not the live clock.
+type message struct {
+	from, to string
+	msg      string
+	clock    interface{}
+	send     EventId // send event that produced the message
+	marker   bool    // snapshot marker, not an application message
+	ready    int     // cluster step from which the message can be delivered
+}
+
+// stampSend records a send event and returns the message to put on the wire.
+func (no *Node) stampSend(msg, to string) message {
+	no.mu.Lock()
+	defer no.mu.Unlock()
+	no.Increment()
+	no.genEvent(fmt.Sprintf("[nodeId -> %s] [msg -> %s] [timestamp -> %v] [event_type -> send]", no.id, msg, no.Get()), "send")
+	return message{
+		from:  no.id,
+		to:    to,
+		msg:   msg,
+		clock: copyTimestamp(no.Get()),
+		send:  no.log[len(no.log)-1].id(),
+	}
+}
 
-	// write the message to the remote connection
-	if _, err := r.Write([]byte(msg)); errors.Is(err, errSystemDown) {
-		time.Sleep(time.Millisecond * 70) // waiting for response
+// deliver merges the clock carried by the message and records the recv.
+// messages to a node that is down are lost.
+func (no *Node) deliver(m message) {
+	no.mu.Lock()
+	if !no.status {
+		no.mu.Unlock()
 		return
 	}
+	no.Merge(clockOf(m.clock))
+	no.genEvent(fmt.Sprintf("[nodeId -> %s] [msg -> %s] [timestamp -> %v] [event_type -> recv]",
+		no.id, m.msg, m.clock), "recv")
+	no.log[len(no.log)-1].from = m.send
+	no.mu.Unlock()
+	// outside the lock, the handler might send something back.
+	if no.onDeliver != nil {
+		no.onDeliver(m)
+	}
+}
 
-	// increment clock before sends.
-	no.Increment()
-	no.genEvent(fmt.Sprintf("[nodeId -> %s] [msg -> %s] [timestamp -> %v] [event_type -> send]", no.id, msg, no.Get()), "send")
+// Real nodes don't stop and wait for each other when they talk, a message
+// is sent and the sender goes on with its life. Whenever the message shows
+// up it sits in the inbox of the receiver until the receiver gets to it,
+// and many of them can be waiting there at the same time. The message
+// carries the clock of the sender from the time it was sent, the sender's
+// clock has probably moved on by the time it is read.
+
+// mailbox is a FIFO of messages that arrived at a node.
+type mailbox struct {
+	mu   sync.Mutex
+	q    []message
+	more chan struct{} // signals the receive loop that messages arrived
+}
 
-	r.c <- 1 // new message alert to remote host
-	<-no.c   // wait for the remote host to finish reading new msg
+func newMailbox() *mailbox {
+	return &mailbox{more: make(chan struct{}, 1)}
 }
 
-func (no *Node) recv(r *Node) {
+func (mb *mailbox) put(m message) {
+	mb.mu.Lock()
+	mb.q = append(mb.q, m)
+	mb.mu.Unlock()
 	select {
-	case <-no.c:
-		// abort if system is down
-		if !no.status {
-			no.buf.Reset()
-			r.c <- 1
-			return
-		}
-		time.Sleep(time.Millisecond * 70) // simulate network latency
-		//b := make([]byte, 0, 100)
-		b, err := io.ReadAll(no)
-		if err != nil {
-			fmt.Errorf("recv error: (nodeId, %s) %v\n", no.id, err)
-			no.buf.Reset()
-			return
-		}
-		no.Merge(r.Clock)
-		no.genEvent(fmt.Sprintf("[nodeId -> %s] [msg -> %s] [timestamp -> %v] [event_type -> recv]",
-			no.id, string(b), r.Get()), "recv")
-		r.c <- 1
-		return
+	case mb.more <- struct{}{}:
+	default: // the loop already knows there is something to read
+	}
+}
+
+func (mb *mailbox) take() (message, bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if len(mb.q) == 0 {
+		return message{}, false
+	}
+	m := mb.q[0]
+	mb.q = mb.q[1:]
+	return m, true
+}
+
+func (mb *mailbox) len() int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return len(mb.q)
+}
+
+// send records a send event and drops the message in the inbox of r.
+// it doesn't wait for r to read it.
+func (no *Node) send(msg string, r *Node) {
+	r.inbox.put(no.stampSend(msg, r.id))
+}
+
+// receive processes the oldest message in the inbox, false if there was
+// nothing to process.
+func (no *Node) receive() bool {
+	m, ok := no.inbox.take()
+	if !ok {
+		return false
+	}
+	no.deliver(m)
+	return true
+}
+
+// drain processes every message in the inbox.
+func (no *Node) drain() {
+	for no.receive() {
 	}
 }
+
+// start launches the receive loop, it processes messages in the order
+// they arrive until the node is stopped.
+func (no *Node) start() {
+	no.stop, no.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(no.done)
+		for {
+			select {
+			case <-no.stop:
+				return
+			case <-no.inbox.more:
+				no.drain()
+			}
+		}
+	}()
+}
+
+// halt stops the receive loop and waits for it to be gone. messages still
+// in the inbox stay there.
+func (no *Node) halt() {
+	close(no.stop)
+	<-no.done
+}
@@ -0,0 +1,148 @@
+package clocks
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNodeSendDoesNotWait(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b")
+	a, b := cl.nodes["a"], cl.nodes["b"]
+
+	a.send("one", b)
+	a.send("two", b)
+	a.genInternalEvent()
+	if b.inbox.len() != 2 || len(b.log) != 0 {
+		t.Fatalf("expected both messages waiting in b's inbox")
+	}
+
+	// b reads them long after a moved on, the clocks in the messages
+	// are still the ones from when they were sent.
+	b.drain()
+	if len(b.log) != 2 || logMsg(b.log[0]) != "one" || logMsg(b.log[1]) != "two" {
+		t.Fatalf("expected messages in arrival order, got %v", b.log)
+	}
+	if got := b.Get().(map[string]int)["a"]; got != 2 {
+		t.Errorf("expected b to know about 2 events of a, got %d", got)
+	}
+	if b.log[1].from != (EventId{"a", 1}) {
+		t.Errorf("expected second recv to come from a:1, got %s", b.log[1].from)
+	}
+}
+
+func TestNodeConcurrentSends(t *testing.T) {
+	ids := []string{"r", "s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7"}
+	cl := NewCluster(NewVectorClock, ids...)
+	r := cl.nodes["r"]
+	r.start()
+
+	var wg sync.WaitGroup
+	for _, id := range ids[1:] {
+		wg.Add(1)
+		go func(s *Node) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				s.send(fmt.Sprintf("%s %d", s.id, i), r)
+			}
+		}(cl.nodes[id])
+	}
+	wg.Wait()
+	r.halt()
+	r.drain() // whatever the loop didn't get to
+
+	if len(r.log) != 8*50 {
+		t.Fatalf("expected %d messages, got %d", 8*50, len(r.log))
+	}
+	g, err := cl.HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := g.CheckClocks(); len(m) != 0 {
+		t.Errorf("clocks disagree with causality: %v", m[0])
+	}
+}
+
+func TestDeliverHandlesInboxInOrder(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b")
+	var got []string
+	cl.onReceive(func(m message) { got = append(got, m.msg) })
+
+	// one is already waiting in b's inbox when two is delivered.
+	cl.nodes["a"].send("one", cl.nodes["b"])
+	if err := cl.Send("a", "b", "two"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Deliver("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	cl.nodes["b"].drain()
+	if len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Errorf("expected handlers to see one then two, got %v", got)
+	}
+}
+
+func TestNodeStatusWhileReceiving(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b")
+	a, b := cl.nodes["a"], cl.nodes["b"]
+	b.start()
+	for i := 0; i < 100; i++ {
+		a.send("ping", b)
+		b.changeStatus(i%2 == 0)
+	}
+	b.halt()
+	b.changeStatus(true)
+	b.drain()
+}
+
+func TestClusterBroadcast(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b", "c", "d")
+	cl.SetNetwork(Network{MinLatency: time.Millisecond, MaxLatency: 100 * time.Millisecond})
+	s := NewScheduler(cl, 5)
+	s.At(0, func() { cl.Broadcast("a", "hello everyone") })
+
+	arrived := make(map[string]time.Duration)
+	for s.Step() {
+		for _, id := range []string{"b", "c", "d"} {
+			if _, ok := arrived[id]; !ok && len(cl.nodes[id].log) == 1 {
+				arrived[id] = s.Now()
+			}
+		}
+	}
+
+	if len(cl.nodes["a"].log) != 1 {
+		t.Fatalf("expected a single send event on a, got %v", cl.nodes["a"].log)
+	}
+	if len(arrived) != 3 {
+		t.Fatalf("expected a recv on every other node, got %v", arrived)
+	}
+	if arrived["b"] == arrived["c"] && arrived["c"] == arrived["d"] {
+		t.Errorf("expected every recipient to get its own latency, got %v", arrived)
+	}
+	for id := range arrived {
+		if cl.nodes[id].log[0].from != (EventId{"a", 0}) {
+			t.Errorf("expected recv on %s to come from the one send", id)
+		}
+	}
+}
+
+func TestClusterMulticast(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b", "c", "d")
+	if err := cl.Multicast("a", []string{"b", "x"}, "hi"); err == nil {
+		t.Error("expected an error for an unknown member of the group")
+	}
+	cl.Multicast("a", []string{"b", "c"}, "hi")
+	cl.Drain()
+
+	if len(cl.nodes["b"].log) != 1 || len(cl.nodes["c"].log) != 1 || len(cl.nodes["d"].log) != 0 {
+		t.Errorf("expected only the group to get the message")
+	}
+	g, err := cl.HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.Concurrent(EventId{"b", 0}, EventId{"c", 0}) {
+		t.Errorf("the two recvs of a multicast are concurrent")
+	}
+}
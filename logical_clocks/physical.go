@@ -0,0 +1,382 @@
+package clocks
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Logical clocks exist because physical ones can't be trusted. Every node
+// has a quartz crystal ticking at a slightly different rate, they start out
+// a little apart and drift further apart every second. Synchronization
+// algorithms bring them back together every so often, but a message still
+// takes time to arrive and nobody knows exactly how long, so the clocks are
+// never quite the same. Order events by what the clocks read and sooner or
+// later a message is received before it was sent.
+//
+// When a scheduler runs the cluster, every node gets a hardware clock that
+// reads the virtual time of the scheduler through its own skew and drift,
+// and every event a node logs is stamped with what its hardware clock read.
+
+var errNoScheduler = errors.New("cluster is not run by a scheduler")
+
+// HardwareClock is how a node's physical clock is off from real time. It
+// reads Skew plus real time sped up or slowed down by Drift, a Drift of
+// 0.0001 gains 100 microseconds every second.
+type HardwareClock struct {
+	Skew  time.Duration
+	Drift float64
+}
+
+type hardwareClock struct {
+	HardwareClock
+	offset time.Duration // adjustments made by synchronization
+	now    func() time.Duration
+}
+
+func (hc *hardwareClock) read() time.Duration {
+	now := hc.now()
+	return now + time.Duration(float64(now)*hc.Drift) + hc.Skew + hc.offset
+}
+
+// adjust steps the clock. stepping it back means it can read the same time
+// twice, real systems slow the clock down instead but that's for another day.
+func (hc *hardwareClock) adjust(d time.Duration) { hc.offset += d }
+
+// attachHardwareClock gives the node a perfect hardware clock running on
+// the virtual time of the scheduler.
+func (s *Scheduler) attachHardwareClock(no *Node) {
+	no.hw = &hardwareClock{now: s.Now}
+}
+
+// SetHardwareClock sets how far off the hardware clock of a node is. The
+// cluster has to be run by a scheduler.
+func (cl *Cluster) SetHardwareClock(id string, hc HardwareClock) error {
+	no := cl.Get(id)
+	if no == nil {
+		return fmt.Errorf("node id not in cluster: %s", id)
+	}
+	if no.hw == nil {
+		return errNoScheduler
+	}
+	no.hw.HardwareClock = hc
+	return nil
+}
+
+// PhysicalTime returns what the hardware clock of a node reads now.
+func (cl *Cluster) PhysicalTime(id string) (time.Duration, error) {
+	no := cl.Get(id)
+	if no == nil {
+		return 0, fmt.Errorf("node id not in cluster: %s", id)
+	}
+	if no.hw == nil {
+		return 0, errNoScheduler
+	}
+	return no.hw.read(), nil
+}
+
+// Cristian's algorithm, a node asks a time server what time it is.
+//
+// -> the node notes the time t0 on its clock and asks the server.
+// -> the server answers with the time T on its clock.
+// -> the node gets the answer at t1. the answer took about half the round
+//    trip to come back, so the node sets its clock to T + (t1 - t0)/2.
+//
+// The error is at most half the round trip minus the fastest a message can
+// travel, as long as the server is right and the link is about symmetric.
+
+const cristianMsg = "cristian "
+
+// Cristian syncs the clocks of a cluster with a time server.
+type Cristian struct {
+	cl     *Cluster
+	server string
+	asked  map[string]time.Duration // when a node asked, on its own clock
+}
+
+// NewCristian starts Cristian's algorithm with the given time server.
+func NewCristian(cl *Cluster, server string) (*Cristian, error) {
+	no := cl.Get(server)
+	if no == nil {
+		return nil, fmt.Errorf("node id not in cluster: %s", server)
+	}
+	if no.hw == nil {
+		return nil, errNoScheduler
+	}
+	c := &Cristian{cl: cl, server: server, asked: make(map[string]time.Duration)}
+	cl.onReceive(c.receive)
+	return c, nil
+}
+
+// Sync makes a node ask the server for the time.
+func (c *Cristian) Sync(id string) error {
+	if id == c.server {
+		return nil
+	}
+	t0, err := c.cl.PhysicalTime(id)
+	if err != nil {
+		return err
+	}
+	if err := c.cl.Send(id, c.server, cristianMsg+"request"); err != nil {
+		return err
+	}
+	c.asked[id] = t0
+	return nil
+}
+
+func (c *Cristian) receive(m message) {
+	if !strings.HasPrefix(m.msg, cristianMsg) {
+		return
+	}
+	body := strings.TrimPrefix(m.msg, cristianMsg)
+	if body == "request" {
+		now, _ := c.cl.PhysicalTime(m.to)
+		c.cl.Send(m.to, m.from, fmt.Sprintf("%sreply %d", cristianMsg, int64(now)))
+		return
+	}
+	server, err := parseDuration(strings.TrimPrefix(body, "reply "))
+	t0, ok := c.asked[m.to]
+	if err != nil || !ok {
+		return
+	}
+	delete(c.asked, m.to)
+	hw := c.cl.nodes[m.to].hw
+	t1 := hw.read()
+	hw.adjust(server + (t1-t0)/2 - t1)
+}
+
+// The Berkeley algorithm, there is no time server everyone trusts. A
+// master asks everyone for their time and gets them to agree on the average.
+//
+// -> the master polls every node, noting when it sent the poll.
+// -> every node answers with its time, the master guesses how far off each
+//    node is from its own clock the same way Cristian's algorithm does.
+// -> the master averages the offsets, leaving out the ones that are too far
+//    off to be trusted, and tells every node how much to move its clock.
+//
+// The clocks end up close to each other, not to real time.
+
+const berkeleyMsg = "berkeley "
+
+// Berkeley syncs the clocks of a cluster with each other.
+type Berkeley struct {
+	cl     *Cluster
+	master string
+
+	// Tolerance leaves out of the average the nodes more than this far off
+	// from the master, they still get adjusted. 0 trusts everyone.
+	Tolerance time.Duration
+
+	round   int
+	polled  time.Duration            // when the master polled, on its own clock
+	offsets map[string]time.Duration // how far every node that answered is off
+}
+
+// NewBerkeley starts the Berkeley algorithm with the given master.
+func NewBerkeley(cl *Cluster, master string) (*Berkeley, error) {
+	no := cl.Get(master)
+	if no == nil {
+		return nil, fmt.Errorf("node id not in cluster: %s", master)
+	}
+	if no.hw == nil || cl.sched == nil {
+		return nil, errNoScheduler
+	}
+	b := &Berkeley{cl: cl, master: master}
+	cl.onReceive(b.receive)
+	return b, nil
+}
+
+// Sync makes the master start a round. It waits long enough for every
+// node that is up to answer, then sends out the adjustments.
+func (b *Berkeley) Sync() error {
+	polled, err := b.cl.PhysicalTime(b.master)
+	if err != nil {
+		return err
+	}
+	if err := b.cl.Broadcast(b.master, fmt.Sprintf("%spoll %d", berkeleyMsg, b.round+1)); err != nil {
+		return err
+	}
+	b.round++
+	b.polled = polled
+	b.offsets = map[string]time.Duration{b.master: 0}
+
+	round := b.round
+	b.cl.sched.After(roundTrip(b.cl), func() {
+		if b.round == round {
+			b.adjust()
+		}
+	})
+	return nil
+}
+
+func (b *Berkeley) receive(m message) {
+	if !strings.HasPrefix(m.msg, berkeleyMsg) {
+		return
+	}
+	// poll <round>, time <round> <t> and adjust <round> <d>
+	f := strings.Fields(strings.TrimPrefix(m.msg, berkeleyMsg))
+	if len(f) < 2 {
+		return
+	}
+	kind := f[0]
+	round, err := strconv.Atoi(f[1])
+	if err != nil {
+		return
+	}
+	var val time.Duration
+	if len(f) > 2 {
+		if val, err = parseDuration(f[2]); err != nil {
+			return
+		}
+	}
+	switch kind {
+	case "poll":
+		now, _ := b.cl.PhysicalTime(m.to)
+		b.cl.Send(m.to, m.from, fmt.Sprintf("%stime %d %d", berkeleyMsg, round, int64(now)))
+	case "time":
+		if round != b.round || b.offsets == nil {
+			return
+		}
+		now := b.cl.nodes[b.master].hw.read()
+		b.offsets[m.from] = val + (now-b.polled)/2 - now
+	case "adjust":
+		b.cl.nodes[m.to].hw.adjust(val)
+	}
+}
+
+// adjust averages the offsets that came in and tells everyone to move.
+func (b *Berkeley) adjust() {
+	var sum time.Duration
+	n := 0
+	for _, off := range b.offsets {
+		if b.Tolerance > 0 && (off > b.Tolerance || off < -b.Tolerance) {
+			continue
+		}
+		sum += off
+		n++
+	}
+	avg := sum / time.Duration(n)
+	for _, id := range b.cl.ids() {
+		off, ok := b.offsets[id]
+		if !ok {
+			continue
+		}
+		if id == b.master {
+			b.cl.nodes[id].hw.adjust(avg)
+			continue
+		}
+		b.cl.Send(b.master, id, fmt.Sprintf("%sadjust %d %d", berkeleyMsg, b.round, int64(avg-off)))
+	}
+	b.offsets = nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return time.Duration(n), err
+}
+
+// SyncSample is how far apart the physical clocks of a cluster are at a
+// point in virtual time.
+type SyncSample struct {
+	At     time.Duration
+	Error  time.Duration // the furthest any node is from real time
+	Spread time.Duration // between the fastest and the slowest node
+}
+
+// SampleSync samples the physical clocks of the live nodes every period
+// until the given virtual time. The samples are in the returned slice once
+// the scheduler has run past them.
+func SampleSync(s *Scheduler, every, until time.Duration) *[]SyncSample {
+	samples := new([]SyncSample)
+	s.Every(every, until, func() {
+		*samples = append(*samples, s.cl.syncSample())
+	})
+	return samples
+}
+
+func (cl *Cluster) syncSample() SyncSample {
+	now := cl.sched.Now()
+	sample := SyncSample{At: now}
+	first := true
+	var lo, hi time.Duration
+	for _, id := range cl.ids() {
+		no := cl.nodes[id]
+		if !no.running() || no.hw == nil {
+			continue
+		}
+		t := no.hw.read()
+		if e := abs(t - now); e > sample.Error {
+			sample.Error = e
+		}
+		if first || t < lo {
+			lo = t
+		}
+		if first || t > hi {
+			hi = t
+		}
+		first = false
+	}
+	sample.Spread = hi - lo
+	return sample
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// PhysicalOrder is how the order of events by physical timestamps compares
+// with happens-before.
+type PhysicalOrder struct {
+	Causal     int    // pairs ordered by happens-before
+	Inverted   []Edge // a -> b but b's physical timestamp is before a's
+	Concurrent int    // concurrent pairs, physical time orders them anyway
+}
+
+// ComparePhysicalOrder goes through the timestamps the hardware clocks put
+// on the events in the log and checks them against happens-before. Lamport
+// and vector timestamps never invert a causal pair, physical ones do when
+// the clocks are further apart than a message takes to travel.
+func ComparePhysicalOrder(cl *Cluster) (PhysicalOrder, error) {
+	g, err := cl.HappensBeforeGraph()
+	if err != nil {
+		return PhysicalOrder{}, err
+	}
+	phys := make(map[EventId]time.Duration)
+	for _, e := range cl.dlog {
+		if e.timed && !isFault(e.status) {
+			phys[e.id()] = e.phys
+		}
+	}
+
+	var po PhysicalOrder
+	events := g.Events()
+	for i, a := range events {
+		for _, b := range events[i+1:] {
+			pa, ok := phys[a]
+			pb, ok2 := phys[b]
+			if !ok || !ok2 {
+				continue
+			}
+			switch {
+			case g.Before(a, b):
+				po.Causal++
+				if pb < pa {
+					po.Inverted = append(po.Inverted, Edge{a, b})
+				}
+			case g.Before(b, a):
+				po.Causal++
+				if pa < pb {
+					po.Inverted = append(po.Inverted, Edge{b, a})
+				}
+			default:
+				po.Concurrent++
+			}
+		}
+	}
+	return po, nil
+}
@@ -0,0 +1,134 @@
+package clocks
+
+import (
+	"testing"
+	"time"
+)
+
+// driftingCluster makes a cluster where every hardware clock is off.
+func driftingCluster(seed int64) (*Cluster, *Scheduler) {
+	cl := NewCluster(NewVectorClock, "a", "b", "c", "d")
+	s := NewScheduler(cl, seed)
+	cl.SetHardwareClock("b", HardwareClock{Skew: 30 * time.Millisecond, Drift: 2e-3})
+	cl.SetHardwareClock("c", HardwareClock{Skew: -40 * time.Millisecond, Drift: -1e-3})
+	cl.SetHardwareClock("d", HardwareClock{Skew: 15 * time.Millisecond, Drift: 5e-3})
+	return cl, s
+}
+
+func worst(samples []SyncSample) (err, spread time.Duration) {
+	for _, s := range samples {
+		if s.Error > err {
+			err = s.Error
+		}
+		if s.Spread > spread {
+			spread = s.Spread
+		}
+	}
+	return err, spread
+}
+
+func TestHardwareClockDrifts(t *testing.T) {
+	cl, s := driftingCluster(1)
+	samples := SampleSync(s, time.Second, 10*time.Second)
+	s.Run()
+
+	if len(*samples) != 11 {
+		t.Fatalf("expected 11 samples, got %d", len(*samples))
+	}
+	first, last := (*samples)[0], (*samples)[10]
+	if last.Error <= first.Error {
+		t.Errorf("expected the clocks to drift away, error went from %v to %v", first.Error, last.Error)
+	}
+	if d, _ := cl.PhysicalTime("d"); d != 10*time.Second+50*time.Millisecond+15*time.Millisecond {
+		t.Errorf("d reads %v", d)
+	}
+	if NewCluster(NewLamportClock, "a").SetHardwareClock("a", HardwareClock{}) != errNoScheduler {
+		t.Error("expected hardware clocks to need a scheduler")
+	}
+}
+
+func TestCristianKeepsClocksClose(t *testing.T) {
+	cl, s := driftingCluster(2)
+	c, err := NewCristian(cl, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Every(500*time.Millisecond, 10*time.Second, func() {
+		for _, id := range cl.ids() {
+			c.Sync(id)
+		}
+	})
+	samples := SampleSync(s, 250*time.Millisecond, 10*time.Second)
+	s.Run()
+
+	// the first samples are before anyone synced.
+	e, spread := worst((*samples)[4:])
+	t.Logf("cristian: worst error %v, worst spread %v", e, spread)
+	if e > DefaultNetwork.MaxLatency {
+		t.Errorf("expected the error to stay under the latency, got %v", e)
+	}
+}
+
+func TestBerkeleyAgreesOnAverage(t *testing.T) {
+	cl, s := driftingCluster(3)
+	// e's clock is way off, it gets fixed but doesn't drag the rest along.
+	cl.Join("e")
+	cl.SetHardwareClock("e", HardwareClock{Skew: 10 * time.Second})
+	b, err := NewBerkeley(cl, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Tolerance = time.Second
+	s.Every(time.Second, 10*time.Second, func() { b.Sync() })
+	samples := SampleSync(s, 250*time.Millisecond, 10*time.Second)
+	s.Run()
+
+	e, spread := worst((*samples)[8:])
+	t.Logf("berkeley: worst error %v, worst spread %v", e, spread)
+	if spread > DefaultNetwork.MaxLatency {
+		t.Errorf("expected the clocks to agree within the latency, spread %v", spread)
+	}
+	if e > 200*time.Millisecond {
+		t.Errorf("e dragged the cluster off, error %v", e)
+	}
+}
+
+func TestPhysicalOrderAgainstHappensBefore(t *testing.T) {
+	order := func(sync bool) PhysicalOrder {
+		cl, s := driftingCluster(4)
+		cl.SetHardwareClock("c", HardwareClock{Skew: -200 * time.Millisecond})
+		if sync {
+			c, _ := NewCristian(cl, "a")
+			s.At(0, func() {
+				for _, id := range cl.ids() {
+					c.Sync(id)
+				}
+			})
+			s.RunUntil(time.Second)
+		}
+		s.RandomWorkload(40, 2*time.Second)
+		s.Run()
+		cl.appendLogs()
+
+		po, err := ComparePhysicalOrder(cl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, _ := cl.HappensBeforeGraph()
+		if mm := g.CheckClocks(); len(mm) != 0 {
+			t.Errorf("vector clocks disagree with causality: %v", mm)
+		}
+		return po
+	}
+
+	unsynced, synced := order(false), order(true)
+	t.Logf("unsynced: %d of %d causal pairs inverted, %d concurrent pairs ordered anyway",
+		len(unsynced.Inverted), unsynced.Causal, unsynced.Concurrent)
+	t.Logf("synced: %d of %d causal pairs inverted", len(synced.Inverted), synced.Causal)
+	if len(unsynced.Inverted) == 0 {
+		t.Error("expected a clock 200ms behind to invert causal pairs")
+	}
+	if len(synced.Inverted) >= len(unsynced.Inverted) {
+		t.Error("expected syncing to invert fewer pairs")
+	}
+}
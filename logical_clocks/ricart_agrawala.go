@@ -0,0 +1,163 @@
+package clocks
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ricart and Agrawala noticed the release messages of lamport's algorithm
+// can be folded into the replies. A node that has a better claim on the
+// critical section than the one asking just doesn't reply yet, it sends the
+// reply when it leaves. A node gets in once everyone has replied.
+//
+// -> to ask, a node sends a timestamped request to everyone else.
+// -> on a request, a node replies right away unless it is in the critical
+//    section or is waiting with an older (timestamp, id) of its own, then
+//    the reply is deferred.
+// -> a node enters when it has a reply from every other node.
+// -> on leaving, a node sends all the replies it deferred.
+//
+// That is 2(N-1) messages per entry, and channels don't have to be FIFO.
+
+const ricartAgrawalaMsg = "ricart-agrawala "
+
+// RicartAgrawala runs the Ricart-Agrawala mutual exclusion algorithm on a cluster.
+type RicartAgrawala struct {
+	cl       *Cluster
+	pending  map[string]*mutexRequest   // request a node is waiting on
+	waiting  map[string]map[string]bool // nodes a waiting node still needs a reply from
+	deferred map[string][]string        // nodes waiting on a reply from a node
+	holding  map[string]bool
+	onEnter  func(id string)
+	messages int
+}
+
+// NewRicartAgrawala starts the algorithm on a cluster running lamport clocks.
+func NewRicartAgrawala(cl *Cluster) (*RicartAgrawala, error) {
+	for _, no := range cl.nodes {
+		if _, ok := no.Clock.(*LamportClock); !ok {
+			return nil, errNotLamport
+		}
+	}
+	ra := &RicartAgrawala{
+		cl:       cl,
+		pending:  make(map[string]*mutexRequest),
+		waiting:  make(map[string]map[string]bool),
+		deferred: make(map[string][]string),
+		holding:  make(map[string]bool),
+	}
+	cl.onReceive(ra.receive)
+	cl.onChange(ra.change)
+	return ra, nil
+}
+
+// Acquire asks for the critical section on behalf of a node.
+func (ra *RicartAgrawala) Acquire(id string) error {
+	if ra.cl.Get(id) == nil {
+		return fmt.Errorf("node id not in cluster: %s", id)
+	}
+	if ra.pending[id] != nil || ra.holding[id] {
+		return errAlreadyAsked
+	}
+	if err := ra.cl.Broadcast(id, ricartAgrawalaMsg+"request"); err != nil {
+		return err
+	}
+	// only the nodes that got the request owe a reply, not the ones
+	// that join later.
+	waiting := make(map[string]bool)
+	for _, other := range ra.cl.ids() {
+		if other != id {
+			waiting[other] = true
+		}
+	}
+	ra.messages += len(waiting)
+	ra.pending[id] = &mutexRequest{ts: ra.cl.nodes[id].Get().(int), id: id}
+	ra.waiting[id] = waiting
+	ra.tryEnter(id)
+	return nil
+}
+
+// Release leaves the critical section and sends the deferred replies.
+func (ra *RicartAgrawala) Release(id string) error {
+	if ra.cl.Get(id) == nil {
+		return fmt.Errorf("node id not in cluster: %s", id)
+	}
+	if !ra.holding[id] {
+		return errNotInCritical
+	}
+	ra.cl.nodes[id].genLocalEvent(csExit, "left critical section")
+	ra.holding[id] = false
+	for _, to := range ra.deferred[id] {
+		ra.reply(id, to)
+	}
+	delete(ra.deferred, id)
+	return nil
+}
+
+// Holding reports whether the node is in the critical section.
+func (ra *RicartAgrawala) Holding(id string) bool { return ra.holding[id] }
+
+// OnEnter sets a function to call whenever a node enters the critical section.
+func (ra *RicartAgrawala) OnEnter(fn func(id string)) { ra.onEnter = fn }
+
+// Messages returns the number of messages sent so far.
+func (ra *RicartAgrawala) Messages() int { return ra.messages }
+
+func (ra *RicartAgrawala) reply(from, to string) {
+	if ra.cl.Send(from, to, ricartAgrawalaMsg+"reply") == nil {
+		ra.messages++
+	}
+}
+
+// receive handles the messages of the algorithm delivered in the cluster.
+func (ra *RicartAgrawala) receive(m message) {
+	if !strings.HasPrefix(m.msg, ricartAgrawalaMsg) {
+		return
+	}
+	switch strings.TrimPrefix(m.msg, ricartAgrawalaMsg) {
+	case "request":
+		req := mutexRequest{ts: m.clock.(int), id: m.from}
+		mine := ra.pending[m.to]
+		if ra.holding[m.to] || (mine != nil && mine.before(req)) {
+			ra.deferred[m.to] = append(ra.deferred[m.to], m.from)
+			return
+		}
+		ra.reply(m.to, m.from)
+	case "reply":
+		if ra.pending[m.to] != nil {
+			delete(ra.waiting[m.to], m.from)
+			ra.tryEnter(m.to)
+		}
+	}
+}
+
+// change forgets a node that left, nobody waits on its reply anymore.
+func (ra *RicartAgrawala) change(id string, joined bool) {
+	if joined {
+		return
+	}
+	delete(ra.pending, id)
+	delete(ra.waiting, id)
+	delete(ra.holding, id)
+	delete(ra.deferred, id)
+	for _, other := range ra.cl.ids() {
+		if ra.pending[other] != nil {
+			delete(ra.waiting[other], id)
+			ra.tryEnter(other)
+		}
+	}
+}
+
+// tryEnter lets the node in once everyone it asked replied.
+func (ra *RicartAgrawala) tryEnter(id string) {
+	if ra.pending[id] == nil || len(ra.waiting[id]) > 0 {
+		return
+	}
+	delete(ra.pending, id)
+	delete(ra.waiting, id)
+	ra.holding[id] = true
+	ra.cl.nodes[id].genLocalEvent(csEnter, "entered critical section")
+	if ra.onEnter != nil {
+		ra.onEnter(id)
+	}
+}
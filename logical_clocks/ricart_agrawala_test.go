@@ -0,0 +1,78 @@
+package clocks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRicartAgrawalaDefersReplies(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b", "c")
+	s := NewScheduler(cl, 2)
+	ra, err := NewRicartAgrawala(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.At(0, func() { ra.Acquire("a") })
+	s.Run()
+	if !ra.Holding("a") {
+		t.Fatal("expected a to get in with nobody else asking")
+	}
+
+	// b has to wait for a's deferred reply.
+	s.At(s.Now(), func() { ra.Acquire("b") })
+	s.Run()
+	if ra.Holding("b") {
+		t.Fatal("b got in while a holds the critical section")
+	}
+	ra.Release("a")
+	s.Run()
+	if !ra.Holding("b") {
+		t.Fatal("expected b to get in after a released")
+	}
+	if ra.Messages() != 2*2*2 {
+		t.Errorf("expected 2(N-1) messages per entry, got %d", ra.Messages())
+	}
+}
+
+// compareMutex runs the same workload with the same faults on a fresh cluster.
+func compareMutex(t *testing.T, name string, newMutex func(*Cluster) (Mutex, error)) MutexStats {
+	cl := NewCluster(NewLamportClock, "a", "b", "c", "d", "e")
+	cl.Schedule(
+		Fault{Step: 20, Kind: Delay, From: "a", To: "c", Steps: 30},
+		Fault{Step: 200, Kind: Heal},
+	)
+	s := NewScheduler(cl, 2021)
+	m, err := newMutex(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := RunMutexWorkload(s, m, 50, 10*time.Millisecond, 3*time.Second)
+	t.Logf("%s: %+v", name, stats)
+
+	overlaps, err := CheckMutualExclusion(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlaps) != 0 {
+		t.Errorf("%s: critical sections overlap: %v", name, overlaps)
+	}
+	if stats.Entries != 50 {
+		t.Errorf("%s: expected 50 entries, got %d", name, stats.Entries)
+	}
+	return stats
+}
+
+func TestCompareMutexAlgorithms(t *testing.T) {
+	lamport := compareMutex(t, "lamport", func(cl *Cluster) (Mutex, error) {
+		return NewLamportMutex(cl)
+	})
+	ra := compareMutex(t, "ricart-agrawala", func(cl *Cluster) (Mutex, error) {
+		return NewRicartAgrawala(cl)
+	})
+
+	if lamport.Messages != 50*3*4 || ra.Messages != 50*2*4 {
+		t.Errorf("expected 3(N-1) and 2(N-1) messages per entry, got %d and %d",
+			lamport.Messages, ra.Messages)
+	}
+}
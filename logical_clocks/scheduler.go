@@ -0,0 +1,227 @@
+package clocks
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Goroutines, time.Sleep and channel handshakes make the cluster feel real,
+// but a run that goes wrong can never be seen again and every message costs
+// 70ms of real time. The scheduler takes over instead. Everything that
+// happens in the cluster is an action scheduled at some point in virtual
+// time, the scheduler runs them one after the other in time order and jumps
+// the clock straight to the next action. All randomness comes from one
+// seeded source, so running again with the same seed gives back exactly
+// the same run.
+
+// Network is the model of the network messages travel on. Every message
+// takes a latency picked uniformly between MinLatency and MaxLatency.
+type Network struct {
+	MinLatency, MaxLatency time.Duration
+}
+
+// DefaultNetwork is the same 10 to 50ms the old random tests slept for.
+var DefaultNetwork = Network{MinLatency: 10 * time.Millisecond, MaxLatency: 50 * time.Millisecond}
+
+// latency picks the latency of a single message.
+func (n Network) latency(rnd *rand.Rand) time.Duration {
+	if n.MaxLatency <= n.MinLatency {
+		return n.MinLatency
+	}
+	return n.MinLatency + time.Duration(rnd.Int63n(int64(n.MaxLatency-n.MinLatency)))
+}
+
+// roundTrip is the longest a request and its answer can take on the
+// cluster's network, with a millisecond of slack. Anything waiting on an
+// answer longer than this can take the other side for dead.
+func roundTrip(cl *Cluster) time.Duration {
+	return 2*cl.net.MaxLatency + time.Millisecond
+}
+
+// action is something scheduled to happen at a point in virtual time.
+// actions at the same time run in the order they were scheduled.
+type action struct {
+	at  time.Duration
+	seq int
+	fn  func()
+}
+
+type actionQueue []*action
+
+func (q actionQueue) Len() int { return len(q) }
+func (q actionQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q actionQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *actionQueue) Push(x interface{}) { *q = append(*q, x.(*action)) }
+func (q *actionQueue) Pop() interface{} {
+	old := *q
+	a := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return a
+}
+
+// Scheduler drives a cluster in virtual time.
+type Scheduler struct {
+	cl   *Cluster
+	now  time.Duration
+	seq  int
+	q    actionQueue
+	seed int64
+	rnd  *rand.Rand
+
+	// lastDelivery[from][to] is when the last message on a link is
+	// delivered, later messages can't arrive before it.
+	lastDelivery map[string]map[string]time.Duration
+}
+
+// NewScheduler attaches a scheduler to the cluster. From now on messages
+// sent in the cluster are delivered by the scheduler.
+func NewScheduler(cl *Cluster, seed int64) *Scheduler {
+	s := &Scheduler{
+		cl:           cl,
+		seed:         seed,
+		rnd:          rand.New(rand.NewSource(seed)),
+		lastDelivery: make(map[string]map[string]time.Duration),
+	}
+	cl.sched = s
+	for _, no := range cl.nodes {
+		s.attachHardwareClock(no)
+	}
+	return s
+}
+
+// Now returns the current virtual time.
+func (s *Scheduler) Now() time.Duration { return s.now }
+
+// Seed returns the seed the run can be replayed from.
+func (s *Scheduler) Seed() int64 { return s.seed }
+
+// Rand returns the random source of the run, anything random in a
+// simulation has to come from here to be replayable.
+func (s *Scheduler) Rand() *rand.Rand { return s.rnd }
+
+// At schedules fn to run at virtual time t, or right away if t is in the past.
+func (s *Scheduler) At(t time.Duration, fn func()) {
+	if t < s.now {
+		t = s.now
+	}
+	s.seq++
+	heap.Push(&s.q, &action{at: t, seq: s.seq, fn: fn})
+}
+
+// After schedules fn to run d after the current virtual time.
+func (s *Scheduler) After(d time.Duration, fn func()) { s.At(s.now+d, fn) }
+
+// Every runs fn every period from now until virtual time t. A period that
+// isn't positive would never get to t, nothing is scheduled for it.
+func (s *Scheduler) Every(period, t time.Duration, fn func()) {
+	if period <= 0 {
+		return
+	}
+	var tick func()
+	tick = func() {
+		fn()
+		if s.now+period <= t {
+			s.After(period, tick)
+		}
+	}
+	s.At(s.now, tick)
+}
+
+// Step runs the next action, false if there is nothing left to run.
+func (s *Scheduler) Step() bool {
+	if len(s.q) == 0 {
+		return false
+	}
+	a := heap.Pop(&s.q).(*action)
+	s.now = a.at
+	a.fn()
+	return true
+}
+
+// Run runs actions until there are none left.
+func (s *Scheduler) Run() {
+	for s.Step() {
+	}
+}
+
+// RunUntil runs every action scheduled up to virtual time t.
+func (s *Scheduler) RunUntil(t time.Duration) {
+	for len(s.q) > 0 && s.q[0].at <= t {
+		s.Step()
+	}
+	if s.now < t {
+		s.now = t
+	}
+}
+
+// Send schedules a message from one node to another at virtual time t.
+func (s *Scheduler) Send(t time.Duration, from, to, msg string) {
+	s.At(t, func() { s.cl.Send(from, to, msg) })
+}
+
+// Internal schedules an internal event on a node at virtual time t.
+func (s *Scheduler) Internal(t time.Duration, id string) {
+	s.At(t, func() { s.cl.Internal(id) })
+}
+
+// Fault makes a fault happen at virtual time t instead of at a step.
+func (s *Scheduler) Fault(t time.Duration, f Fault) {
+	s.At(t, func() { s.cl.apply(f) })
+}
+
+// deliverLater schedules the delivery of the message that was just put on
+// the link. Deliveries on a link never overtake each other so it stays FIFO.
+func (s *Scheduler) deliverLater(from, to string) {
+	if s.lastDelivery[from] == nil {
+		s.lastDelivery[from] = make(map[string]time.Duration)
+	}
+	at := s.now + s.cl.net.latency(s.rnd)
+	if last := s.lastDelivery[from][to]; at < last {
+		at = last
+	}
+	s.lastDelivery[from][to] = at
+	s.At(at, func() { s.deliver(from, to) })
+}
+
+// deliver delivers the head of the link, trying again a little later if
+// a delay fault is holding it up.
+func (s *Scheduler) deliver(from, to string) {
+	if err := s.cl.Deliver(from, to); errors.Is(err, errMessageDelayed) {
+		retry := s.cl.net.MinLatency
+		if retry <= 0 {
+			retry = time.Millisecond
+		}
+		s.After(retry, func() { s.deliver(from, to) })
+	}
+}
+
+// RandomWorkload schedules n random events spread over the given window of
+// virtual time, two thirds of them sends between random nodes and the rest
+// internal events.
+func (s *Scheduler) RandomWorkload(n int, window time.Duration) {
+	ids := s.cl.ids()
+	if len(ids) < 2 {
+		return
+	}
+	for i := 0; i < n; i++ {
+		t := s.now + time.Duration(s.rnd.Int63n(int64(window)))
+		from := ids[s.rnd.Intn(len(ids))]
+		if s.rnd.Intn(3) == 0 {
+			s.Internal(t, from)
+			continue
+		}
+		to := ids[s.rnd.Intn(len(ids)-1)]
+		if to == from {
+			to = ids[len(ids)-1]
+		}
+		s.Send(t, from, to, fmt.Sprintf("send event from %s to %s", from, to))
+	}
+}
@@ -0,0 +1,118 @@
+package clocks
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// runOf returns everything that was recorded in a run, to compare runs.
+func runOf(cl *Cluster) []string {
+	cl.appendLogs()
+	run := make([]string, len(cl.dlog))
+	for i, e := range cl.dlog {
+		run[i] = fmt.Sprintf("%s %s %v", e.id(), e.msg, e.from)
+	}
+	return run
+}
+
+func TestSchedulerReplay(t *testing.T) {
+	run := func(seed int64) []string {
+		cl := NewCluster(NewVectorClock, "a", "b", "c", "d")
+		cl.Schedule(Fault{Step: 50, Kind: Partition, Nodes: []string{"a", "b"}},
+			Fault{Step: 150, Kind: Heal})
+		s := NewScheduler(cl, seed)
+		s.Fault(300*time.Millisecond, Fault{Kind: Crash, Nodes: []string{"d"}})
+		s.RandomWorkload(200, time.Second)
+		s.Run()
+		return runOf(cl)
+	}
+
+	a, b := run(42), run(42)
+	if len(a) != len(b) {
+		t.Fatalf("same seed gave runs of %d and %d entries", len(a), len(b))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("same seed gave different runs at %d:\n%s\n%s", i, a[i], b[i])
+		}
+	}
+
+	c := run(43)
+	same := len(a) == len(c)
+	for i := 0; same && i < len(a); i++ {
+		same = a[i] == c[i]
+	}
+	if same {
+		t.Error("expected a different seed to give a different run")
+	}
+}
+
+func TestSchedulerVirtualTime(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b")
+	cl.SetNetwork(Network{MinLatency: 5 * time.Millisecond, MaxLatency: 5 * time.Millisecond})
+	s := NewScheduler(cl, 1)
+
+	s.Send(time.Second, "a", "b", "hi")
+
+	start := time.Now()
+	s.RunUntil(time.Second + 4*time.Millisecond)
+	if len(cl.nodes["b"].log) != 0 {
+		t.Errorf("message arrived before its latency was up")
+	}
+	s.RunUntil(time.Second + 5*time.Millisecond)
+	if len(cl.nodes["b"].log) != 1 || s.Now() != time.Second+5*time.Millisecond {
+		t.Errorf("expected message at 1.005s of virtual time, its %v", s.Now())
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("a second of virtual time should not take real time")
+	}
+}
+
+func TestSchedulerEvery(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a")
+	s := NewScheduler(cl, 1)
+	ticks := 0
+	s.Every(time.Second, 3*time.Second, func() { ticks++ })
+	s.Every(0, time.Second, func() { ticks += 100 })
+	s.Every(-time.Second, time.Second, func() { ticks += 100 })
+	s.Run()
+	if ticks != 4 {
+		t.Errorf("expected ticks at 0, 1, 2 and 3s and none without a period, got %d", ticks)
+	}
+}
+
+func TestSchedulerKeepsLinksFIFO(t *testing.T) {
+	cl := NewCluster(NewLamportClock, "a", "b")
+	cl.SetNetwork(Network{MinLatency: time.Millisecond, MaxLatency: 100 * time.Millisecond})
+	cl.Schedule(Fault{Step: 10, Kind: Delay, From: "a", To: "b", Steps: 5})
+	s := NewScheduler(cl, 7)
+	for i := 0; i < 50; i++ {
+		s.Send(time.Duration(i)*time.Millisecond, "a", "b", fmt.Sprint(i))
+	}
+	s.Run()
+
+	if len(cl.nodes["b"].log) != 50 {
+		t.Fatalf("expected every message to arrive, got %d", len(cl.nodes["b"].log))
+	}
+	for i, e := range cl.nodes["b"].log {
+		if logMsg(e) != fmt.Sprint(i) {
+			t.Fatalf("expected message %d at position %d, got %s", i, i, logMsg(e))
+		}
+	}
+}
+
+func TestSchedulerManySteps(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b", "c", "d", "e")
+	s := NewScheduler(cl, 3)
+	s.RandomWorkload(5000, 10*time.Second)
+
+	start := time.Now()
+	s.Run()
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("5000 simulated events took %v", d)
+	}
+	if cl.Step() < 5000 {
+		t.Errorf("expected at least 5000 steps, got %d", cl.Step())
+	}
+}
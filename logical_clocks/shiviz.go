@@ -0,0 +1,38 @@
+package clocks
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Joe-Degs/distributed_systems/logical_clocks/shiviz"
+)
+
+// ShiViz turns the logs of a vector clock cluster into events for ShiViz.
+// Faults are left out, they don't move the clocks.
+func (cl *Cluster) ShiViz() ([]shiviz.Event, error) {
+	var events []shiviz.Event
+	for _, id := range cl.everyone() {
+		for _, e := range cl.logOf(id) {
+			ts, ok := e.timestamp.(map[string]int)
+			if !ok {
+				return nil, errNotVector
+			}
+			events = append(events, shiviz.Event{
+				Host:  id,
+				Clock: ts,
+				Text:  fmt.Sprintf("%s %s", e.status, logMsg(e)),
+			})
+		}
+	}
+	return events, nil
+}
+
+// WriteShiViz writes the logs of a vector clock cluster in the format of
+// shiviz.Regex.
+func (cl *Cluster) WriteShiViz(w io.Writer) error {
+	events, err := cl.ShiViz()
+	if err != nil {
+		return err
+	}
+	return shiviz.Write(w, events)
+}
@@ -0,0 +1,72 @@
+// Package shiviz writes runs in a format ShiViz can load.
+//
+// ShiViz (https://bestchai.bitbucket.io/shiviz/) draws the space-time
+// diagram of a log and lets you poke at it in the browser. It wants every
+// event on a line with the host it happened on and its vector clock as a
+// json object, and a regular expression telling it where each part is.
+// Every event of a host must move the host's own entry in the clock
+// forward, ShiViz uses it to put the events of a host in order.
+package shiviz
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Regex is the expression to paste into ShiViz for logs written by Write.
+// ShiViz speaks javascript, so the groups are named the javascript way.
+const Regex = `(?<host>\S+) (?<clock>\{[^}]*\}) (?<event>.*)`
+
+// the same expression for go.
+var lineRe = regexp.MustCompile(`^(?P<host>\S+) (?P<clock>\{[^}]*\}) (?P<event>.*)$`)
+
+// Event is one line of the log.
+type Event struct {
+	Host  string
+	Clock map[string]int
+	Text  string
+}
+
+// Write writes the events one per line. json sorts the ids in the clocks.
+func Write(w io.Writer, events []Event) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range events {
+		if strings.ContainsAny(e.Host, " \t\n") {
+			return fmt.Errorf("shiviz: host %q has white space in it", e.Host)
+		}
+		clock, err := json.Marshal(e.Clock)
+		if err != nil {
+			return err
+		}
+		text := strings.Replace(e.Text, "\n", " ", -1)
+		if _, err := fmt.Fprintf(bw, "%s %s %s\n", e.Host, clock, text); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Parse reads back a log written by Write.
+func Parse(r io.Reader) ([]Event, error) {
+	var events []Event
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		if sc.Text() == "" {
+			continue
+		}
+		m := lineRe.FindStringSubmatch(sc.Text())
+		if m == nil {
+			return nil, fmt.Errorf("shiviz: line %d doesn't match", n)
+		}
+		e := Event{Host: m[1], Text: m[3]}
+		if err := json.Unmarshal([]byte(m[2]), &e.Clock); err != nil {
+			return nil, fmt.Errorf("shiviz: line %d: %v", n, err)
+		}
+		events = append(events, e)
+	}
+	return events, sc.Err()
+}
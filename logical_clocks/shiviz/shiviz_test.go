@@ -0,0 +1,46 @@
+package shiviz
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteParse(t *testing.T) {
+	events := []Event{
+		{Host: "a", Clock: map[string]int{"a": 1}, Text: "send hello"},
+		{Host: "b", Clock: map[string]int{"a": 1, "b": 1}, Text: "recv hello\nworld"},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, events); err != nil {
+		t.Fatal(err)
+	}
+	want := "a {\"a\":1} send hello\nb {\"a\":1,\"b\":1} recv hello world\n"
+	if buf.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, buf.String())
+	}
+
+	back, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events[1].Text = "recv hello world"
+	if !reflect.DeepEqual(back, events) {
+		t.Errorf("expected %v back, got %v", events, back)
+	}
+
+	if err := Write(&buf, []Event{{Host: "a b"}}); err == nil {
+		t.Error("expected hosts with spaces to be refused")
+	}
+	if _, err := Parse(strings.NewReader("not a shiviz line")); err == nil {
+		t.Error("expected an error on a line that doesn't match")
+	}
+}
+
+func TestRegexMatchesGoVersion(t *testing.T) {
+	goRe := "^" + strings.Replace(Regex, "(?<", "(?P<", -1) + "$"
+	if goRe != lineRe.String() {
+		t.Errorf("the regex for ShiViz and the one Parse uses are different:\n%s\n%s", Regex, lineRe)
+	}
+}
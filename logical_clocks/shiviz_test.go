@@ -0,0 +1,45 @@
+package clocks
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Joe-Degs/distributed_systems/logical_clocks/shiviz"
+)
+
+func TestWriteShiViz(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b", "peer")
+	cl.Send("a", "peer", "hello")
+	cl.Internal("b")
+	cl.Drain()
+	cl.Broadcast("peer", "hi all")
+	cl.Drain()
+
+	var buf bytes.Buffer
+	if err := cl.WriteShiViz(&buf); err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + buf.String())
+	events, err := shiviz.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 6 {
+		t.Fatalf("expected 6 events, got %d", len(events))
+	}
+
+	// every event of a host moves its own entry forward by one.
+	last := make(map[string]int)
+	for _, e := range events {
+		if e.Clock[e.Host] != last[e.Host]+1 {
+			t.Errorf("%s: own entry went from %d to %d", e.Host, last[e.Host], e.Clock[e.Host])
+		}
+		last[e.Host] = e.Clock[e.Host]
+	}
+
+	lc := NewCluster(NewLamportClock, "a")
+	lc.Internal("a")
+	if err := lc.WriteShiViz(&buf); err != errNotVector {
+		t.Errorf("expected %v, got %v", errNotVector, err)
+	}
+}
@@ -0,0 +1,144 @@
+package clocks
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Chandy-Lamport snapshots.
+// There is no global clock to stop every node at the same instant and
+// ask for its state, so we need some other way to get a picture of the
+// whole system that could have happened. The trick is the marker, a special
+// message that splits every channel in two, what was sent before the marker
+// and what was sent after.
+//
+// -> the initiator records its own state and sends a marker on every
+//    outgoing channel.
+// -> when a node sees a marker for the first time it records its state,
+//    marks the channel the marker came on as empty, starts recording every
+//    other incoming channel and sends markers on all its outgoing channels.
+// -> when a node sees a marker again it stops recording on that channel,
+//    whatever it recorded is what was in flight on it.
+//
+// This only works because channels are FIFO, a message sent before the
+// marker can't overtake it. The snapshot is done when every node has seen
+// a marker on every incoming channel.
+
+var errSnapshotRunning = errors.New("a snapshot is already in progress")
+
+// LocalState is the state a node recorded for a snapshot.
+type LocalState struct {
+	Clock       interface{} // timestamp of the node when it recorded
+	LogPosition int         // number of events in the node's log
+}
+
+// Snapshot is a consistent global snapshot of the cluster.
+type Snapshot struct {
+	Initiator string
+	States    map[string]LocalState
+
+	// Channels[to][from] holds the messages that were in flight from one
+	// node to another when the snapshot was taken.
+	Channels map[string]map[string][]string
+}
+
+// Cut returns the snapshot as a cut, the number of events of every node
+// that are part of it. A snapshot taken by Chandy-Lamport is always
+// consistent, every recv in the cut has its send in the cut too.
+func (s *Snapshot) Cut() map[string]int {
+	cut := make(map[string]int, len(s.States))
+	for id, st := range s.States {
+		cut[id] = st.LogPosition
+	}
+	return cut
+}
+
+// snapshotState keeps track of a snapshot that is still being taken.
+type snapshotState struct {
+	snap *Snapshot
+
+	// recording[to][from] is true while messages from one node to another
+	// are being recorded as in flight.
+	recording map[string]map[string]bool
+	remaining int // incoming channels still waiting for a marker
+}
+
+// record saves a message that arrives on a channel being recorded.
+func (ss *snapshotState) record(m message) {
+	if ss.recording[m.to][m.from] {
+		ss.snap.Channels[m.to][m.from] = append(ss.snap.Channels[m.to][m.from], m.msg)
+	}
+}
+
+// snapshotRunning reports whether the markers of a snapshot are still on
+// their way, cl.snap stays around after it's done for Snapshot to return.
+func (cl *Cluster) snapshotRunning() bool {
+	return cl.snap != nil && cl.snap.remaining > 0
+}
+
+// StartSnapshot starts a snapshot from the given node. Markers travel on
+// the links like any other message, the snapshot is complete once they
+// have all been delivered.
+func (cl *Cluster) StartSnapshot(id string) error {
+	if cl.Get(id) == nil {
+		return fmt.Errorf("node id not in cluster: %s", id)
+	}
+	if cl.snapshotRunning() {
+		return errSnapshotRunning
+	}
+	n := len(cl.nodes)
+	cl.snap = &snapshotState{
+		snap: &Snapshot{
+			Initiator: id,
+			States:    make(map[string]LocalState),
+			Channels:  make(map[string]map[string][]string),
+		},
+		recording: make(map[string]map[string]bool),
+		remaining: n * (n - 1),
+	}
+	cl.recordState(id, "")
+	return nil
+}
+
+// Snapshot returns the last snapshot taken, false if it is still in progress
+// or no snapshot was started.
+func (cl *Cluster) Snapshot() (*Snapshot, bool) {
+	if cl.snap == nil || cl.snap.remaining > 0 {
+		return nil, false
+	}
+	return cl.snap.snap, true
+}
+
+// recordState records the state of a node, starts recording every incoming
+// channel except the one the marker came on and sends out markers.
+func (cl *Cluster) recordState(id, markerFrom string) {
+	no := cl.nodes[id]
+	ss := cl.snap
+	ss.snap.States[id] = LocalState{
+		Clock:       copyTimestamp(no.Get()),
+		LogPosition: len(no.log),
+	}
+	ss.snap.Channels[id] = make(map[string][]string)
+	ss.recording[id] = make(map[string]bool)
+	for _, other := range cl.ids() {
+		if other == id {
+			continue
+		}
+		ss.snap.Channels[id][other] = nil
+		ss.recording[id][other] = other != markerFrom
+		cl.post(message{from: id, to: other, marker: true})
+	}
+}
+
+// recvMarker handles a marker that arrived from one node to another.
+func (cl *Cluster) recvMarker(from, to string) {
+	ss := cl.snap
+	if ss == nil {
+		return
+	}
+	if _, ok := ss.snap.States[to]; !ok {
+		cl.recordState(to, from)
+	}
+	ss.recording[to][from] = false
+	ss.remaining--
+}
@@ -0,0 +1,111 @@
+package clocks
+
+import (
+	"testing"
+)
+
+// checkSnapshot checks the snapshot against the happens-before graph of
+// the run, the cut must be consistent and the recorded channels must hold
+// exactly the messages sent inside the cut and received outside of it.
+func checkSnapshot(t *testing.T, cl *Cluster, snap *Snapshot) {
+	t.Helper()
+	g, err := cl.HappensBeforeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := snap.Cut()
+	inCut := func(id EventId) bool { return id.Seq < cut[id.Node] }
+
+	inFlight := make(map[string]map[string][]string)
+	for _, e := range g.events {
+		if e.status != "recv" {
+			continue
+		}
+		if inCut(e.id()) && !inCut(e.from) {
+			t.Errorf("inconsistent cut, %s is in the cut but its send %s is not", e.id(), e.from)
+		}
+		if inCut(e.from) && !inCut(e.id()) {
+			if inFlight[e.nodeId] == nil {
+				inFlight[e.nodeId] = make(map[string][]string)
+			}
+			sent := cl.nodes[e.from.Node].log[e.from.Seq]
+			inFlight[e.nodeId][e.from.Node] = append(inFlight[e.nodeId][e.from.Node], logMsg(sent))
+		}
+	}
+
+	for to, chans := range snap.Channels {
+		for from, msgs := range chans {
+			want := inFlight[to][from]
+			if len(msgs) != len(want) {
+				t.Errorf("channel %s -> %s: expected in flight %v, got %v", from, to, want, msgs)
+				continue
+			}
+			for i := range msgs {
+				if msgs[i] != want[i] {
+					t.Errorf("channel %s -> %s: expected %q, got %q", from, to, want[i], msgs[i])
+				}
+			}
+		}
+	}
+}
+
+func TestChandyLamportSnapshot(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b", "c")
+	cl.Send("a", "b", "m1")
+	cl.Send("b", "c", "m2")
+
+	if err := cl.StartSnapshot("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.StartSnapshot("b"); err == nil {
+		t.Errorf("expected only one snapshot at a time")
+	}
+
+	// c hasn't seen a marker, m3 is sent before it records its state
+	// and reaches a after a recorded, so its in flight in the snapshot.
+	cl.Deliver("b", "c")
+	cl.Send("c", "a", "m3")
+	cl.Deliver("c", "a")
+	if _, ok := cl.Snapshot(); ok {
+		t.Fatal("snapshot can't be done with markers still in flight")
+	}
+
+	cl.Drain()
+	snap, ok := cl.Snapshot()
+	if !ok {
+		t.Fatal("expected snapshot to be done after draining the links")
+	}
+	if msgs := snap.Channels["a"]["c"]; len(msgs) != 1 || msgs[0] != "m3" {
+		t.Errorf("expected m3 in flight from c to a, got %v", msgs)
+	}
+	if snap.States["a"].LogPosition != 1 {
+		t.Errorf("expected a to record after its send, got %d", snap.States["a"].LogPosition)
+	}
+	checkSnapshot(t, cl, snap)
+}
+
+func TestChandyLamportSnapshotAnyInitiator(t *testing.T) {
+	for _, initiator := range []string{"a", "b", "c", "d"} {
+		cl := NewCluster(NewLamportClock, "a", "b", "c", "d")
+		cl.Send("a", "b", "m1")
+		cl.Send("c", "d", "m2")
+		cl.Send("d", "a", "m3")
+		cl.Deliver("a", "b")
+
+		if err := cl.StartSnapshot(initiator); err != nil {
+			t.Fatal(err)
+		}
+		cl.Send("b", "c", "m4")
+		cl.Send("a", "d", "m5")
+		cl.Drain()
+
+		snap, ok := cl.Snapshot()
+		if !ok {
+			t.Fatalf("%s: expected snapshot to be done", initiator)
+		}
+		if snap.Initiator != initiator {
+			t.Errorf("expected initiator %s, got %s", initiator, snap.Initiator)
+		}
+		checkSnapshot(t, cl, snap)
+	}
+}
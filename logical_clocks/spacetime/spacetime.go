@@ -0,0 +1,254 @@
+// Package spacetime draws space-time diagrams of recorded runs.
+//
+// Every node gets a lane, time runs left to right along it and every event
+// is a dot on its lane with its timestamp next to it. Messages are arrows
+// from the send to the recv. That's the picture every paper on clocks draws
+// on the whiteboard, and it's a lot easier on the eyes than reading
+// "[nodeId -> a] [msg -> ...]" lines out of a log.
+//
+// The package knows nothing about where the run came from, callers turn
+// their logs into a Diagram and the package draws it as SVG or Graphviz DOT.
+package spacetime
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+// kinds of events drawn differently, anything else is drawn plain.
+const (
+	Send     = "send"
+	Recv     = "recv"
+	Internal = "internal"
+)
+
+// EventRef points at an event by its node and its position on the lane.
+type EventRef struct {
+	Node string
+	Seq  int
+}
+
+func (r EventRef) String() string { return fmt.Sprintf("%s:%d", r.Node, r.Seq) }
+
+// Event is a single event on a lane.
+type Event struct {
+	Node  string
+	Seq   int    // position on the lane, from 0
+	Kind  string // send, recv, internal or whatever else the recorder has
+	Label string // shown next to the event, usually the timestamp
+}
+
+func (e Event) ref() EventRef { return EventRef{e.Node, e.Seq} }
+
+// Message is an arrow from a send to the recv of the same message.
+type Message struct {
+	From, To EventRef
+	Label    string
+}
+
+// Diagram is everything that gets drawn.
+type Diagram struct {
+	Nodes    []string // lanes from top to bottom, nodes with events are added if missing
+	Events   []Event
+	Messages []Message
+}
+
+var errCycle = errors.New("spacetime: messages go back in time")
+
+// layout works out the lanes and the column of every event. An event sits
+// one column after the event before it on its lane, and after the send of
+// a message it receives, so every arrow points to the right.
+func (d Diagram) layout() (lanes []string, col map[EventRef]int, width int, err error) {
+	seen := make(map[string]bool)
+	for _, n := range d.Nodes {
+		if !seen[n] {
+			seen[n] = true
+			lanes = append(lanes, n)
+		}
+	}
+	byNode := make(map[string][]Event)
+	var extra []string
+	for _, e := range d.Events {
+		if !seen[e.Node] {
+			seen[e.Node] = true
+			extra = append(extra, e.Node)
+		}
+		byNode[e.Node] = append(byNode[e.Node], e)
+	}
+	sort.Strings(extra)
+	lanes = append(lanes, extra...)
+	for _, evs := range byNode {
+		sort.Slice(evs, func(i, j int) bool { return evs[i].Seq < evs[j].Seq })
+	}
+
+	// a message with an end outside the diagram isn't drawn, its recv is
+	// placed like a local event.
+	in := make(map[EventRef]bool)
+	for _, e := range d.Events {
+		in[e.ref()] = true
+	}
+	sendOf := make(map[EventRef]EventRef)
+	for _, m := range d.Messages {
+		if in[m.From] && in[m.To] {
+			sendOf[m.To] = m.From
+		}
+	}
+
+	// keep placing whatever can be placed until everything is.
+	col = make(map[EventRef]int)
+	next := make(map[string]int) // next event to place on every lane
+	for placed := 0; placed < len(d.Events); {
+		progress := false
+		for _, n := range lanes {
+			for next[n] < len(byNode[n]) {
+				e := byNode[n][next[n]]
+				c := 0
+				if next[n] > 0 {
+					c = col[byNode[n][next[n]-1].ref()] + 1
+				}
+				if from, ok := sendOf[e.ref()]; ok {
+					fc, ok := col[from]
+					if !ok {
+						break // the send isn't placed yet
+					}
+					if fc+1 > c {
+						c = fc + 1
+					}
+				}
+				col[e.ref()] = c
+				if c+1 > width {
+					width = c + 1
+				}
+				next[n]++
+				placed++
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, nil, 0, errCycle
+		}
+	}
+	return lanes, col, width, nil
+}
+
+// drawn says if a message has both ends in the diagram.
+func drawn(col map[EventRef]int, m Message) bool {
+	_, from := col[m.From]
+	_, to := col[m.To]
+	return from && to
+}
+
+// sizes of the svg drawing.
+const (
+	margin  = 80
+	laneGap = 90
+	colGap  = 70
+	radius  = 5
+)
+
+var colors = map[string]string{
+	Send:     "#1f77b4",
+	Recv:     "#2ca02c",
+	Internal: "#7f7f7f",
+}
+
+func color(kind string) string {
+	if c, ok := colors[kind]; ok {
+		return c
+	}
+	return "#d62728"
+}
+
+// WriteSVG draws the diagram as an SVG image.
+func (d Diagram) WriteSVG(w io.Writer) error {
+	lanes, col, width, err := d.layout()
+	if err != nil {
+		return err
+	}
+	laneY := make(map[string]int)
+	for i, n := range lanes {
+		laneY[n] = margin + i*laneGap
+	}
+	x := func(r EventRef) int { return 2*margin + col[r]*colGap }
+	w2 := 3*margin + width*colGap
+	h := 2*margin + (len(lanes)-1)*laneGap
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="11">`+"\n", w2, h)
+	b.WriteString(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M0,0 L10,5 L0,10 z"/></marker></defs>` + "\n")
+	for _, n := range lanes {
+		y := laneY[n]
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="14" text-anchor="end">%s</text>`+"\n", margin, y+4, html.EscapeString(n))
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", margin+10, y, w2-margin/2, y)
+	}
+	for _, m := range d.Messages {
+		if !drawn(col, m) {
+			continue
+		}
+		x1, y1, x2, y2 := x(m.From), laneY[m.From.Node], x(m.To), laneY[m.To.Node]
+		// stop the arrow at the edge of the dot.
+		if y2 > y1 {
+			y2 -= radius
+		} else {
+			y2 += radius
+		}
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#555" marker-end="url(#arrow)"><title>%s</title></line>`+"\n",
+			x1, y1, x2, y2, html.EscapeString(m.Label))
+	}
+	for _, e := range d.Events {
+		r := e.ref()
+		fmt.Fprintf(&b, `<circle cx="%d" cy="%d" r="%d" fill="%s"><title>%s %s</title></circle>`+"\n",
+			x(r), laneY[e.Node], radius, color(e.Kind), r, html.EscapeString(e.Kind))
+		if e.Label != "" {
+			fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n",
+				x(r), laneY[e.Node]-10, html.EscapeString(e.Label))
+		}
+	}
+	b.WriteString("</svg>\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// WriteDOT writes the diagram as a Graphviz graph, every lane a row of
+// nodes and messages dashed edges between them. dot -Tsvg draws it.
+func (d Diagram) WriteDOT(w io.Writer) error {
+	lanes, col, _, err := d.layout()
+	if err != nil {
+		return err
+	}
+	byNode := make(map[string][]Event)
+	for _, e := range d.Events {
+		byNode[e.Node] = append(byNode[e.Node], e)
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph spacetime {\n\trankdir=LR;\n\tnode [shape=circle, style=filled, fixedsize=true, width=0.15, label=\"\"];\n")
+	for i, n := range lanes {
+		evs := byNode[n]
+		sort.Slice(evs, func(i, j int) bool { return evs[i].Seq < evs[j].Seq })
+		fmt.Fprintf(&b, "\tsubgraph cluster_%d {\n\t\tlabel=%q; style=invis;\n", i, n)
+		for _, e := range evs {
+			fmt.Fprintf(&b, "\t\t%q [fillcolor=%q, xlabel=%q, tooltip=%q];\n",
+				e.ref().String(), color(e.Kind), e.Label, e.Kind)
+		}
+		for j := 1; j < len(evs); j++ {
+			fmt.Fprintf(&b, "\t\t%q -> %q [arrowhead=none, weight=100, minlen=%d];\n",
+				evs[j-1].ref().String(), evs[j].ref().String(), col[evs[j].ref()]-col[evs[j-1].ref()])
+		}
+		b.WriteString("\t}\n")
+	}
+	for _, m := range d.Messages {
+		if !drawn(col, m) {
+			continue
+		}
+		fmt.Fprintf(&b, "\t%q -> %q [style=dashed, constraint=false, tooltip=%q];\n",
+			m.From.String(), m.To.String(), m.Label)
+	}
+	b.WriteString("}\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
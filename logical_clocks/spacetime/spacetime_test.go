@@ -0,0 +1,94 @@
+package spacetime
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// a sends to b, b does some work and replies.
+func pingPong() Diagram {
+	return Diagram{
+		Nodes: []string{"a", "b"},
+		Events: []Event{
+			{Node: "a", Seq: 0, Kind: Send, Label: "1"},
+			{Node: "a", Seq: 1, Kind: Recv, Label: "5"},
+			{Node: "b", Seq: 0, Kind: Internal, Label: "1"},
+			{Node: "b", Seq: 1, Kind: Recv, Label: "2"},
+			{Node: "b", Seq: 2, Kind: Internal, Label: "3"},
+			{Node: "b", Seq: 3, Kind: Send, Label: "4"},
+		},
+		Messages: []Message{
+			{From: EventRef{"a", 0}, To: EventRef{"b", 1}, Label: "ping"},
+			{From: EventRef{"b", 3}, To: EventRef{"a", 1}, Label: "pong"},
+		},
+	}
+}
+
+func TestLayout(t *testing.T) {
+	lanes, col, width, err := pingPong().layout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(lanes, " ") != "a b" {
+		t.Errorf("expected lanes a b, got %v", lanes)
+	}
+	for _, m := range pingPong().Messages {
+		if col[m.To] <= col[m.From] {
+			t.Errorf("%s arrow points back: %d -> %d", m.Label, col[m.From], col[m.To])
+		}
+	}
+	if col[EventRef{"a", 1}] != 4 || width != 5 {
+		t.Errorf("expected the pong to land in column 4 of 5, got %d of %d", col[EventRef{"a", 1}], width)
+	}
+}
+
+func TestLayoutCycle(t *testing.T) {
+	d := pingPong()
+	// a's first event can't receive something b sends after hearing from a.
+	d.Messages = append(d.Messages, Message{From: EventRef{"b", 3}, To: EventRef{"a", 0}})
+	if _, _, _, err := d.layout(); err != errCycle {
+		t.Errorf("expected %v, got %v", errCycle, err)
+	}
+}
+
+func TestLayoutMissingSend(t *testing.T) {
+	d := pingPong()
+	// the send of this one was cut out of the diagram.
+	d.Messages = append(d.Messages, Message{From: EventRef{"c", 0}, To: EventRef{"b", 0}})
+	_, col, _, err := d.layout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if col[EventRef{"b", 0}] != 0 {
+		t.Errorf("expected the recv to be placed like a local event, got column %d", col[EventRef{"b", 0}])
+	}
+}
+
+func TestWriteSVGAndDOT(t *testing.T) {
+	d := pingPong()
+	d.Events = append(d.Events, Event{Node: "c", Seq: 0, Kind: "crash", Label: "<x>"})
+
+	var svg bytes.Buffer
+	if err := d.WriteSVG(&svg); err != nil {
+		t.Fatal(err)
+	}
+	out := svg.String()
+	if n := strings.Count(out, "<circle"); n != 7 {
+		t.Errorf("expected 7 events drawn, got %d", n)
+	}
+	if n := strings.Count(out, `marker-end="url(#arrow)"`); n != 2 {
+		t.Errorf("expected 2 arrows, got %d", n)
+	}
+	if !strings.Contains(out, "&lt;x&gt;") {
+		t.Error("labels should be escaped")
+	}
+
+	var dot bytes.Buffer
+	if err := d.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dot.String(), `"a:0" -> "b:1" [style=dashed`) {
+		t.Errorf("expected the ping in the graph:\n%s", dot.String())
+	}
+}
@@ -0,0 +1,45 @@
+package clocks
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Joe-Degs/distributed_systems/logical_clocks/lattice"
+)
+
+var errNotVector = errors.New("cluster does not run vector clocks")
+
+// Trace turns the logs of a vector clock cluster into a trace for the
+// lattice package, so global predicates can be checked on recorded runs.
+func (cl *Cluster) Trace() (lattice.Trace, error) {
+	trace := make(lattice.Trace, len(cl.nodes))
+	for _, id := range cl.everyone() {
+		trace[id] = make([]lattice.Event, 0, len(cl.logOf(id)))
+		for _, e := range cl.logOf(id) {
+			ts, ok := e.timestamp.(map[string]int)
+			if !ok {
+				return nil, errNotVector
+			}
+			trace[id] = append(trace[id], lattice.Event{
+				Node:  id,
+				Clock: ts,
+				Kind:  e.status,
+				Label: logMsg(e),
+			})
+		}
+	}
+	return trace, nil
+}
+
+// logMsg pulls the message out of the formatted log line.
+func logMsg(e eventLog) string {
+	i := strings.Index(e.msg, "[msg -> ")
+	if i < 0 {
+		return e.msg
+	}
+	msg := e.msg[i+len("[msg -> "):]
+	if j := strings.Index(msg, "] [timestamp"); j >= 0 {
+		msg = msg[:j]
+	}
+	return msg
+}
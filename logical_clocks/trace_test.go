@@ -0,0 +1,45 @@
+package clocks
+
+import (
+	"testing"
+
+	"github.com/Joe-Degs/distributed_systems/logical_clocks/lattice"
+)
+
+func TestClusterTrace(t *testing.T) {
+	cl := NewCluster(NewVectorClock, "a", "b")
+	cl.Send("a", "b", "ping")
+	cl.nodes["b"].genInternalEvent()
+	cl.Deliver("a", "b")
+	cl.Send("b", "a", "pong")
+	cl.Deliver("b", "a")
+
+	trace, err := cl.Trace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace["a"]) != 2 || len(trace["b"]) != 3 {
+		t.Fatalf("expected 2 events on a and 3 on b, got %v", trace)
+	}
+	if trace["a"][0].Label != "ping" || trace["b"][1].Kind != "recv" {
+		t.Errorf("events not carried over from the logs: %v", trace)
+	}
+
+	// ping is in flight in some consistent state, but pong never is before
+	// ping was received.
+	pingInFlight := func(s lattice.State) bool {
+		return s.Index("a") >= 1 && s.Index("b") < 2
+	}
+	if _, ok := lattice.Possibly(trace, pingInFlight); !ok {
+		t.Error("expected ping to possibly be in flight")
+	}
+	if !lattice.Definitely(trace, pingInFlight) {
+		t.Error("every run has ping in flight at some point")
+	}
+
+	lamport := NewCluster(NewLamportClock, "a")
+	lamport.nodes["a"].genInternalEvent()
+	if _, err := lamport.Trace(); err == nil {
+		t.Error("expected lamport clusters to have no trace")
+	}
+}
@@ -0,0 +1,184 @@
+package clocks
+
+import (
+	"fmt"
+	"time"
+)
+
+// Spanner went the other way. Instead of giving up on physical clocks it
+// owns up to how wrong they are, TrueTime doesn't answer with the time but
+// with an interval the real time is guaranteed to be in. A transaction is
+// stamped with the latest the time could be, then waits until the earliest
+// the time could be is past its stamp before it tells anyone it committed.
+// Whatever starts after that, anywhere, gets a bigger stamp. That's external
+// consistency, the order of the timestamps is the order things happened in
+// the real world, without the nodes talking to each other at all. The price
+// is the wait, twice the uncertainty on every commit.
+//
+// Lamport clocks can't do that. Two nodes that never talk keep their own
+// counts and the later transaction can easily get the smaller timestamp.
+
+// TimeSource is where an interval clock gets the time from. The scheduler
+// is one, so interval clocks run in virtual time.
+type TimeSource interface {
+	Now() time.Duration
+	After(d time.Duration, fn func()) // runs fn d from now
+}
+
+// wallClock is a TimeSource on the real clock of the machine.
+type wallClock struct{ start time.Time }
+
+func (w wallClock) Now() time.Duration               { return time.Since(w.start) }
+func (w wallClock) After(d time.Duration, fn func()) { time.AfterFunc(d, fn) }
+
+// WallClock returns a time source that reads real time since it was made.
+func WallClock() TimeSource { return wallClock{start: time.Now()} }
+
+// nodeTime reads the hardware clock of a node and waits in scheduler time.
+type nodeTime struct {
+	no *Node
+	s  *Scheduler
+}
+
+func (n nodeTime) Now() time.Duration               { return n.no.hw.read() }
+func (n nodeTime) After(d time.Duration, fn func()) { n.s.After(d, fn) }
+
+// Interval is a stretch of time the real time is somewhere in.
+type Interval struct {
+	Earliest, Latest time.Duration
+}
+
+func (i Interval) String() string { return fmt.Sprintf("[%v, %v]", i.Earliest, i.Latest) }
+
+// IntervalClock is a clock that knows how wrong it can be.
+type IntervalClock struct {
+	src         TimeSource
+	uncertainty time.Duration
+}
+
+// NewIntervalClock makes an interval clock that trusts the time source to
+// be at most uncertainty off from real time.
+func NewIntervalClock(src TimeSource, uncertainty time.Duration) *IntervalClock {
+	return &IntervalClock{src: src, uncertainty: uncertainty}
+}
+
+// Now returns the interval real time is in right now.
+func (c *IntervalClock) Now() Interval {
+	t := c.src.Now()
+	return Interval{Earliest: t - c.uncertainty, Latest: t + c.uncertainty}
+}
+
+// After reports whether t is definitely in the past.
+func (c *IntervalClock) After(t time.Duration) bool { return c.Now().Earliest > t }
+
+// Before reports whether t is definitely in the future.
+func (c *IntervalClock) Before(t time.Duration) bool { return c.Now().Latest < t }
+
+// CommitWait calls fn once t is definitely in the past.
+func (c *IntervalClock) CommitWait(t time.Duration, fn func()) {
+	if c.After(t) {
+		fn()
+		return
+	}
+	// the source can run fast or slow, so check again when it should be over.
+	c.src.After(t-c.Now().Earliest+1, func() { c.CommitWait(t, fn) })
+}
+
+// Transaction is a transaction committed by a node.
+type Transaction struct {
+	Node          string
+	Start, Commit time.Duration // real time it started and got acknowledged
+	Timestamp     time.Duration // TrueTime commit timestamp
+	Lamport       int           // lamport clock of the commit event
+}
+
+// TxnPair is two transactions that happened one after the other in real
+// time but a timestamp puts them the other way round.
+type TxnPair struct {
+	First, Second Transaction
+}
+
+const txnMsg = "txn "
+
+// TxnStore commits transactions on the nodes of a cluster Spanner-style,
+// every node has an interval clock on its hardware clock. The hardware
+// clocks must not be further off from real time than the uncertainty.
+type TxnStore struct {
+	cl     *Cluster
+	s      *Scheduler
+	clocks map[string]*IntervalClock
+	txns   []Transaction
+}
+
+// NewTxnStore sets up an interval clock with the given uncertainty on every
+// node of a cluster running lamport clocks.
+func NewTxnStore(cl *Cluster, s *Scheduler, uncertainty time.Duration) (*TxnStore, error) {
+	ts := &TxnStore{cl: cl, s: s, clocks: make(map[string]*IntervalClock)}
+	for id, no := range cl.nodes {
+		if _, ok := no.Clock.(*LamportClock); !ok {
+			return nil, errNotLamport
+		}
+		ts.clocks[id] = NewIntervalClock(nodeTime{no: no, s: s}, uncertainty)
+	}
+	return ts, nil
+}
+
+// Clock returns the interval clock of a node.
+func (ts *TxnStore) Clock(id string) *IntervalClock { return ts.clocks[id] }
+
+// Commit commits a transaction on a node. It is stamped with the latest
+// the time could be and acknowledged after the commit wait, then the other
+// nodes are told about it. done is called with the transaction when it is
+// acknowledged.
+func (ts *TxnStore) Commit(id string, done func(Transaction)) error {
+	c, ok := ts.clocks[id]
+	if !ok {
+		return fmt.Errorf("node id not in cluster: %s", id)
+	}
+	no := ts.cl.nodes[id]
+	if !no.running() {
+		return errSystemDown
+	}
+	txn := Transaction{Node: id, Start: ts.s.Now(), Timestamp: c.Now().Latest}
+	no.genLocalEvent("txn-start", fmt.Sprintf("transaction stamped %v", txn.Timestamp))
+	c.CommitWait(txn.Timestamp, func() {
+		no.genLocalEvent("txn-commit", fmt.Sprintf("transaction %v committed", txn.Timestamp))
+		txn.Commit, txn.Lamport = ts.s.Now(), no.Get().(int)
+		ts.txns = append(ts.txns, txn)
+		ts.cl.Broadcast(id, fmt.Sprintf("%scommit %d", txnMsg, int64(txn.Timestamp)))
+		if done != nil {
+			done(txn)
+		}
+	})
+	return nil
+}
+
+// Transactions returns the transactions committed so far.
+func (ts *TxnStore) Transactions() []Transaction { return ts.txns }
+
+// ByTrueTime orders transactions by their TrueTime timestamps.
+func ByTrueTime(a, b Transaction) bool { return a.Timestamp < b.Timestamp }
+
+// ByLamport orders transactions by the lamport clocks of their commits,
+// ties broken by node id.
+func ByLamport(a, b Transaction) bool {
+	if a.Lamport != b.Lamport {
+		return a.Lamport < b.Lamport
+	}
+	return a.Node < b.Node
+}
+
+// ExternalOrderViolations returns the pairs of transactions where one was
+// acknowledged before the other started in real time, but less doesn't
+// put the first before the second.
+func ExternalOrderViolations(txns []Transaction, less func(a, b Transaction) bool) []TxnPair {
+	var pairs []TxnPair
+	for _, a := range txns {
+		for _, b := range txns {
+			if a.Commit < b.Start && !less(a, b) {
+				pairs = append(pairs, TxnPair{a, b})
+			}
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,77 @@
+package clocks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIntervalClockCommitWait(t *testing.T) {
+	s := NewScheduler(NewCluster(NewLamportClock, "a"), 1)
+	c := NewIntervalClock(s, 7*time.Millisecond)
+
+	s.RunUntil(100 * time.Millisecond)
+	now := c.Now()
+	if now.Earliest != 93*time.Millisecond || now.Latest != 107*time.Millisecond {
+		t.Fatalf("expected [93ms, 107ms], got %v", now)
+	}
+	if c.After(now.Latest) || c.Before(now.Earliest) {
+		t.Error("the ends of the interval should not be definitely past or future")
+	}
+
+	var waited time.Duration
+	c.CommitWait(now.Latest, func() { waited = s.Now() - 100*time.Millisecond })
+	s.Run()
+	if waited <= 14*time.Millisecond || waited > 15*time.Millisecond {
+		t.Errorf("expected to wait twice the uncertainty, waited %v", waited)
+	}
+	if !c.After(now.Latest) {
+		t.Error("expected the timestamp to be in the past after the wait")
+	}
+}
+
+func TestTxnStoreExternalConsistency(t *testing.T) {
+	const eps = 5 * time.Millisecond
+	cl := NewCluster(NewLamportClock, "a", "b", "c")
+	s := NewScheduler(cl, 2024)
+	// the clocks are off, but not by more than the uncertainty.
+	cl.SetHardwareClock("a", HardwareClock{Skew: 4 * time.Millisecond})
+	cl.SetHardwareClock("b", HardwareClock{Skew: -3 * time.Millisecond})
+	cl.SetHardwareClock("c", HardwareClock{Skew: -5 * time.Millisecond})
+	ts, err := NewTxnStore(cl, s, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a is busy with work of its own, so its lamport clock runs ahead.
+	for i := 0; i < 50; i++ {
+		cl.Internal("a")
+	}
+	ids := cl.ids()
+	for i := 0; i < 40; i++ {
+		id := ids[s.Rand().Intn(len(ids))]
+		s.At(time.Duration(s.Rand().Int63n(int64(2*time.Second))), func() { ts.Commit(id, nil) })
+	}
+	s.Run()
+
+	txns := ts.Transactions()
+	if len(txns) != 40 {
+		t.Fatalf("expected 40 transactions, got %d", len(txns))
+	}
+	for _, txn := range txns {
+		if txn.Commit-txn.Start < 2*eps-2*time.Millisecond {
+			t.Errorf("transaction on %s didn't commit wait: %v", txn.Node, txn.Commit-txn.Start)
+		}
+	}
+	if v := ExternalOrderViolations(txns, ByTrueTime); len(v) != 0 {
+		t.Errorf("truetime timestamps out of real time order: %v", v)
+	}
+	lamport := ExternalOrderViolations(txns, ByLamport)
+	t.Logf("lamport timestamps put %d pairs of transactions out of real time order", len(lamport))
+	if len(lamport) == 0 {
+		t.Error("expected lamport timestamps to get some pairs out of order")
+	}
+
+	if _, err := NewTxnStore(NewCluster(NewVectorClock, "a"), s, eps); err != errNotLamport {
+		t.Errorf("expected %v, got %v", errNotLamport, err)
+	}
+}